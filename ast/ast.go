@@ -10,6 +10,8 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+	// Pos returns the position of the first token of the node.
+	Pos() token.Position
 }
 
 type Statement interface {
@@ -34,6 +36,13 @@ func (p *Program) String() string {
 	return writer.String()
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
 func (p *Program) TokenLiteral() string {
 	if len(p.Statements) > 0 {
 		return p.Statements[0].TokenLiteral()
@@ -63,6 +72,8 @@ func (ls *LetStatement) String() string {
 
 func (ls *LetStatement) statementNode() {}
 
+func (ls *LetStatement) Pos() token.Position { return ls.Token.Pos }
+
 func (ls *LetStatement) TokenLiteral() string {
 	return ls.Token.Literal
 }
@@ -74,6 +85,8 @@ type Identifier struct {
 
 func (id *Identifier) expressionNode() {}
 
+func (id *Identifier) Pos() token.Position { return id.Token.Pos }
+
 func (id *Identifier) TokenLiteral() string {
 	return id.Token.Literal
 }
@@ -99,6 +112,8 @@ func (rs *ReturnStatement) String() string {
 
 func (rs *ReturnStatement) statementNode() {}
 
+func (rs *ReturnStatement) Pos() token.Position { return rs.Token.Pos }
+
 func (rs *ReturnStatement) TokenLiteral() string {
 	return rs.Token.Literal
 }
@@ -115,6 +130,7 @@ func (es *ExpressionStatement) String() string {
 	return ""
 }
 func (es *ExpressionStatement) statementNode()       {}
+func (es *ExpressionStatement) Pos() token.Position  { return es.Token.Pos }
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
 
 type IntegerLiteral struct {
@@ -124,8 +140,19 @@ type IntegerLiteral struct {
 
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 func (il *IntegerLiteral) expressionNode()      {}
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Pos }
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
 
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+func (fl *FloatLiteral) expressionNode()      {}
+func (fl *FloatLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+
 type PrefixExpression struct {
 	Token    token.Token
 	Operator string
@@ -149,6 +176,8 @@ func (pe *PrefixExpression) String() string {
 
 func (pe *PrefixExpression) expressionNode() {}
 
+func (pe *PrefixExpression) Pos() token.Position { return pe.Token.Pos }
+
 type InfixExpression struct {
 	Token    token.Token
 	Left     Expression
@@ -174,6 +203,47 @@ func (pe *InfixExpression) String() string {
 
 func (pe *InfixExpression) expressionNode() {}
 
+func (pe *InfixExpression) Pos() token.Position {
+	if pe.Left == nil {
+		return pe.Token.Pos
+	}
+	return pe.Left.Pos()
+}
+
+// AssignExpression is `x = v`, `a[i] = v` or a compound form such as
+// `x += v`. Target is an *Identifier or an *IndexExpression.
+type AssignExpression struct {
+	Token    token.Token // the assignment operator
+	Target   Expression
+	Operator string
+	Value    Expression
+}
+
+func (ae *AssignExpression) TokenLiteral() string {
+	return ae.Token.Literal
+}
+
+func (ae *AssignExpression) String() string {
+	var writer bytes.Buffer
+
+	writer.WriteString("(")
+	writer.WriteString(ae.Target.String())
+	writer.WriteString(" " + ae.Operator + " ")
+	writer.WriteString(ae.Value.String())
+	writer.WriteString(")")
+
+	return writer.String()
+}
+
+func (ae *AssignExpression) expressionNode() {}
+
+func (ae *AssignExpression) Pos() token.Position {
+	if ae.Target == nil {
+		return ae.Token.Pos
+	}
+	return ae.Target.Pos()
+}
+
 type Boolean struct {
 	Token token.Token
 	Value bool
@@ -182,6 +252,7 @@ type Boolean struct {
 func (pe *Boolean) TokenLiteral() string { return pe.Token.Literal }
 func (pe *Boolean) String() string       { return pe.Token.Literal }
 func (pe *Boolean) expressionNode()      {}
+func (pe *Boolean) Pos() token.Position  { return pe.Token.Pos }
 
 type IfExpression struct {
 	Token       token.Token
@@ -204,7 +275,8 @@ func (ie *IfExpression) String() string {
 	}
 	return writer.String()
 }
-func (ie *IfExpression) expressionNode() {}
+func (ie *IfExpression) expressionNode()     {}
+func (ie *IfExpression) Pos() token.Position { return ie.Token.Pos }
 
 type BlockStatement struct {
 	Token      token.Token
@@ -219,7 +291,8 @@ func (bs *BlockStatement) String() string {
 	}
 	return writer.String()
 }
-func (bs *BlockStatement) expressionNode() {}
+func (bs *BlockStatement) expressionNode()     {}
+func (bs *BlockStatement) Pos() token.Position { return bs.Token.Pos }
 
 type FunctionLiteral struct {
 	Token      token.Token
@@ -248,7 +321,8 @@ func (fl *FunctionLiteral) String() string {
 
 	return writer.String()
 }
-func (fl *FunctionLiteral) expressionNode() {}
+func (fl *FunctionLiteral) expressionNode()     {}
+func (fl *FunctionLiteral) Pos() token.Position { return fl.Token.Pos }
 
 type CallExpression struct {
 	Token     token.Token
@@ -273,6 +347,12 @@ func (ce *CallExpression) String() string {
 	return writer.String()
 }
 func (ce *CallExpression) expressionNode() {}
+func (ce *CallExpression) Pos() token.Position {
+	if ce.Function == nil {
+		return ce.Token.Pos
+	}
+	return ce.Function.Pos()
+}
 
 type StringLiteral struct {
 	Token token.Token
@@ -281,15 +361,46 @@ type StringLiteral struct {
 
 func (sl *StringLiteral) String() string       { return sl.Token.Literal }
 func (sl *StringLiteral) expressionNode()      {}
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Pos }
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
 
+// InterpolatedString is a string literal with embedded expressions such as
+// "a ${b} c". Parts holds the literal text, as StringLiterals, and the
+// embedded expressions in source order.
+type InterpolatedString struct {
+	Token token.Token // the TEMPLATE_HEAD token
+	Parts []Expression
+}
+
+func (is *InterpolatedString) expressionNode()      {}
+func (is *InterpolatedString) Pos() token.Position  { return is.Token.Pos }
+func (is *InterpolatedString) TokenLiteral() string { return is.Token.Literal }
+func (is *InterpolatedString) String() string {
+	var writer bytes.Buffer
+
+	writer.WriteString(`"`)
+	for _, part := range is.Parts {
+		if str, ok := part.(*StringLiteral); ok {
+			writer.WriteString(str.Value)
+			continue
+		}
+		writer.WriteString("${")
+		writer.WriteString(part.String())
+		writer.WriteString("}")
+	}
+	writer.WriteString(`"`)
+
+	return writer.String()
+}
+
 type ArrayLiteral struct {
 	Token    token.Token
 	Elements []Expression
 }
 
-func (al *ArrayLiteral) String() string  { return al.Token.Literal }
-func (al *ArrayLiteral) expressionNode() {}
+func (al *ArrayLiteral) String() string      { return al.Token.Literal }
+func (al *ArrayLiteral) expressionNode()     {}
+func (al *ArrayLiteral) Pos() token.Position { return al.Token.Pos }
 func (al *ArrayLiteral) TokenLiteral() string {
 	var writer bytes.Buffer
 
@@ -313,6 +424,12 @@ type IndexExpression struct {
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.Position {
+	if ie.Left == nil {
+		return ie.Token.Pos
+	}
+	return ie.Left.Pos()
+}
 func (ie *IndexExpression) String() string {
 	var writer bytes.Buffer
 
@@ -331,6 +448,7 @@ type HashLiteral struct {
 }
 
 func (hl *HashLiteral) expressionNode()      {}
+func (hl *HashLiteral) Pos() token.Position  { return hl.Token.Pos }
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
 func (hl *HashLiteral) String() string {
 	var writer bytes.Buffer
@@ -346,3 +464,112 @@ func (hl *HashLiteral) String() string {
 
 	return writer.String()
 }
+
+type WhileStatement struct {
+	Token     token.Token
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) statementNode()       {}
+func (ws *WhileStatement) Pos() token.Position  { return ws.Token.Pos }
+func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+func (ws *WhileStatement) String() string {
+	var writer bytes.Buffer
+
+	writer.WriteString("while")
+	writer.WriteString(ws.Condition.String())
+	writer.WriteString(" ")
+	writer.WriteString(ws.Body.String())
+
+	return writer.String()
+}
+
+// ForStatement is `for (Variable in Iterable) { Body }`.
+type ForStatement struct {
+	Token    token.Token
+	Variable *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fs *ForStatement) statementNode()       {}
+func (fs *ForStatement) Pos() token.Position  { return fs.Token.Pos }
+func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForStatement) String() string {
+	var writer bytes.Buffer
+
+	writer.WriteString("for(")
+	writer.WriteString(fs.Variable.String())
+	writer.WriteString(" in ")
+	writer.WriteString(fs.Iterable.String())
+	writer.WriteString(") ")
+	writer.WriteString(fs.Body.String())
+
+	return writer.String()
+}
+
+type BreakStatement struct {
+	Token token.Token
+}
+
+func (bs *BreakStatement) statementNode()       {}
+func (bs *BreakStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) String() string       { return bs.Token.Literal + ";" }
+
+type ContinueStatement struct {
+	Token token.Token
+}
+
+func (cs *ContinueStatement) statementNode()       {}
+func (cs *ContinueStatement) Pos() token.Position  { return cs.Token.Pos }
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStatement) String() string       { return cs.Token.Literal + ";" }
+
+// TryStatement is `try { Body } catch (Param) { Catch } finally { Finally }`.
+// Param and Catch are nil without a catch clause, Param also with a
+// `catch { }` that ignores the exception, and Finally is nil without a
+// finally clause.
+type TryStatement struct {
+	Token   token.Token
+	Body    *BlockStatement
+	Param   *Identifier
+	Catch   *BlockStatement
+	Finally *BlockStatement
+}
+
+func (ts *TryStatement) statementNode()       {}
+func (ts *TryStatement) Pos() token.Position  { return ts.Token.Pos }
+func (ts *TryStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *TryStatement) String() string {
+	var writer bytes.Buffer
+
+	writer.WriteString("try ")
+	writer.WriteString(ts.Body.String())
+	if ts.Catch != nil {
+		writer.WriteString(" catch ")
+		if ts.Param != nil {
+			writer.WriteString("(" + ts.Param.String() + ") ")
+		}
+		writer.WriteString(ts.Catch.String())
+	}
+	if ts.Finally != nil {
+		writer.WriteString(" finally ")
+		writer.WriteString(ts.Finally.String())
+	}
+
+	return writer.String()
+}
+
+type ThrowStatement struct {
+	Token token.Token
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode()       {}
+func (ts *ThrowStatement) Pos() token.Position  { return ts.Token.Pos }
+func (ts *ThrowStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *ThrowStatement) String() string {
+	return ts.Token.Literal + " " + ts.Value.String() + ";"
+}
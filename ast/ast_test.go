@@ -25,3 +25,47 @@ func TestProgram_String(t *testing.T) {
 		t.Errorf("p.String() wrong. got=%q", p.String())
 	}
 }
+
+func TestInspect(t *testing.T) {
+	program := &Program{
+		Statements: []Statement{
+			&ExpressionStatement{
+				Expression: &InfixExpression{
+					Left:     &Identifier{Value: "a"},
+					Operator: "+",
+					Right: &CallExpression{
+						Function:  &Identifier{Value: "f"},
+						Arguments: []Expression{&Identifier{Value: "b"}},
+					},
+				},
+			},
+			&ExpressionStatement{
+				Expression: &IfExpression{
+					Condition:   &Identifier{Value: "c"},
+					Consequence: &BlockStatement{},
+				},
+			},
+		},
+	}
+
+	var names []string
+	Inspect(program, func(n Node) bool {
+		if _, ok := n.(*CallExpression); ok {
+			return false
+		}
+		if id, ok := n.(*Identifier); ok {
+			names = append(names, id.Value)
+		}
+		return true
+	})
+
+	expected := []string{"a", "c"}
+	if len(names) != len(expected) {
+		t.Fatalf("wrong identifiers visited. want=%v, got=%v", expected, names)
+	}
+	for i, name := range expected {
+		if names[i] != name {
+			t.Errorf("wrong identifier at %d. want=%q, got=%q", i, name, names[i])
+		}
+	}
+}
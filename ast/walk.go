@@ -0,0 +1,97 @@
+package ast
+
+// Inspect traverses the tree rooted at node in depth-first order, calling f
+// for every node. Children of a node are skipped when f returns false.
+func Inspect(node Node, f func(Node) bool) {
+	if isNilNode(node) || !f(node) {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		for _, s := range n.Statements {
+			Inspect(s, f)
+		}
+	case *BlockStatement:
+		for _, s := range n.Statements {
+			Inspect(s, f)
+		}
+	case *LetStatement:
+		Inspect(n.Name, f)
+		Inspect(n.Value, f)
+	case *ReturnStatement:
+		Inspect(n.ReturnValue, f)
+	case *ExpressionStatement:
+		Inspect(n.Expression, f)
+	case *PrefixExpression:
+		Inspect(n.Right, f)
+	case *InfixExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Right, f)
+	case *AssignExpression:
+		Inspect(n.Target, f)
+		Inspect(n.Value, f)
+	case *IfExpression:
+		Inspect(n.Condition, f)
+		Inspect(n.Consequence, f)
+		Inspect(n.Alternative, f)
+	case *WhileStatement:
+		Inspect(n.Condition, f)
+		Inspect(n.Body, f)
+	case *ForStatement:
+		Inspect(n.Variable, f)
+		Inspect(n.Iterable, f)
+		Inspect(n.Body, f)
+	case *TryStatement:
+		Inspect(n.Body, f)
+		Inspect(n.Param, f)
+		Inspect(n.Catch, f)
+		Inspect(n.Finally, f)
+	case *ThrowStatement:
+		Inspect(n.Value, f)
+	case *FunctionLiteral:
+		for _, p := range n.Parameters {
+			Inspect(p, f)
+		}
+		Inspect(n.Body, f)
+	case *CallExpression:
+		Inspect(n.Function, f)
+		for _, a := range n.Arguments {
+			Inspect(a, f)
+		}
+	case *InterpolatedString:
+		for _, p := range n.Parts {
+			Inspect(p, f)
+		}
+	case *ArrayLiteral:
+		for _, e := range n.Elements {
+			Inspect(e, f)
+		}
+	case *IndexExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Index, f)
+	case *HashLiteral:
+		for k, v := range n.Pairs {
+			Inspect(k, f)
+			Inspect(v, f)
+		}
+	}
+}
+
+// isNilNode reports whether node is nil or a nil pointer stored in the
+// interface, which the parser leaves behind for missing optional parts.
+func isNilNode(node Node) bool {
+	switch n := node.(type) {
+	case nil:
+		return true
+	case *BlockStatement:
+		return n == nil
+	case *Identifier:
+		return n == nil
+	case *FunctionLiteral:
+		return n == nil
+	case *ReturnStatement:
+		return n == nil
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package benchmark
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"monkey/compiler"
@@ -13,7 +14,7 @@ import (
 	"time"
 )
 
-var input = `
+const fibonacci = `
 let fibonacci = fn(x) {
   if (x == 0) {
     0
@@ -25,19 +26,13 @@ let fibonacci = fn(x) {
     }
   }
 };
-fibonacci(35);
 `
 
+var input = fibonacci + "fibonacci(35);\n"
+
 func Test(t *testing.T) {
 	flag.Parse()
 
-	var (
-		duration1 time.Duration
-		duration2 time.Duration
-		result1   object.Object
-		result2   object.Object
-	)
-
 	l := lexer.New(input)
 	p := parser.New(l)
 	program := p.ParseProgram()
@@ -53,26 +48,55 @@ func Test(t *testing.T) {
 
 	start := time.Now()
 
-	err = machine.Run()
+	err = machine.Run(context.Background())
 	if err != nil {
 		fmt.Printf("vm error: %s", err)
 		return
 	}
-	duration1 = time.Since(start)
-	result1 = machine.LastPoppedStackElem()
-
-	env := object.NewEnvironment()
-	start = time.Now()
-	result2 = evaluator.Eval(program, env)
-	duration2 = time.Since(start)
+	duration := time.Since(start)
 
 	fmt.Printf(
 		"engine=vm, result=%s, duration=%s\n",
-		result1.Inspect(),
-		duration1)
+		machine.LastPoppedStackElem().Inspect(),
+		duration)
+
+	env := object.NewEnvironment()
+	start = time.Now()
+	result := evaluator.Eval(program, env)
+	duration = time.Since(start)
 
 	fmt.Printf(
 		"engine=eval, result=%s, duration=%s\n",
-		result2.Inspect(),
-		duration2)
+		result.Inspect(),
+		duration)
+}
+
+func BenchmarkFibonacciVM(b *testing.B) {
+	benchmarkVM(b)
+}
+
+// BenchmarkFibonacciVMOptimized runs the same program with the peephole pass
+// and superinstructions enabled. Compare the two with
+//
+//	go test ./benchmark -run '^$' -bench . -benchtime 1x -count 3
+func BenchmarkFibonacciVMOptimized(b *testing.B) {
+	benchmarkVM(b, compiler.WithOptimizations(true))
+}
+
+func benchmarkVM(b *testing.B, opts ...compiler.Option) {
+	program := parser.New(lexer.New(input)).ParseProgram()
+
+	comp := compiler.New(opts...)
+	if err := comp.Compile(program); err != nil {
+		b.Fatalf("compiler error: %s", err)
+	}
+	bytecode := comp.Bytecode()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		machine := vm.New(bytecode)
+		if err := machine.Run(context.Background()); err != nil {
+			b.Fatalf("vm error: %s", err)
+		}
+	}
 }
@@ -93,6 +93,75 @@ const (
 	OpClosure
 	OpGetFree
 	OpCurrentClosure
+
+	// Locals that are assigned and captured by a closure live in cells, so
+	// every closure sharing them sees updates.
+	OpMakeCell
+	OpGetLocalCell
+	OpSetLocalCell
+	OpGetFreeCell
+	OpSetFreeCell
+
+	// OpSetIndex pops a value, an index and the array or hash on top of the
+	// stack, stores the value there and pushes it back.
+	OpSetIndex
+
+	// OpGetIter replaces the value on top of the stack with an iterator over
+	// it. OpIterNext pushes the iterator's next item, or pops the exhausted
+	// iterator and jumps to its operand.
+	OpGetIter
+	OpIterNext
+
+	// OpJumpTruthyOrPop and OpJumpNotTruthyOrPop implement || and &&: they
+	// jump, leaving the value on top of the stack as the result, if it
+	// decides the outcome, and pop it otherwise.
+	OpJumpTruthyOrPop
+	OpJumpNotTruthyOrPop
+
+	OpMod
+	OpPow
+	OpBitAnd
+	OpBitOr
+	OpBitXor
+	OpShiftLeft
+	OpShiftRight
+	OpBitNot
+	OpGreaterThanOrEqual
+
+	// OpConcat joins its operand's number of values from the stack into one
+	// string, using Inspect for values that are not strings.
+	OpConcat
+
+	// OpJumpTruthy pops the value on top of the stack and jumps if it is
+	// truthy. The compiler only emits it when optimizing OpBang followed by
+	// OpJumpNotTruthy.
+	OpJumpTruthy
+
+	// OpTailCall is OpCall in tail position. A call to a closure replaces
+	// the current frame instead of pushing a new one.
+	OpTailCall
+
+	// Superinstructions fuse sequences that are common in hot code. The
+	// peephole pass selects them when optimizing:
+	//
+	//   OpGetLocalConstAdd l c   OpGetLocal l; OpConstant c; OpAdd
+	//   OpGetLocalConstSub l c   OpGetLocal l; OpConstant c; OpSub
+	//   OpCompareJump pos op     op; OpJumpNotTruthy pos, for a comparison op
+	//   OpCall1                  OpCall 1
+	OpGetLocalConstAdd
+	OpGetLocalConstSub
+	OpCompareJump
+	OpCall1
+
+	// OpThrow pops the value on top of the stack and raises it as an
+	// exception. It unwinds the stack to the innermost handler of the
+	// HandlerTable of the functions being run that covers the instruction
+	// raising it, see Handler.
+	OpThrow
+
+	// OpDup2 pushes copies of the two values on top of the stack, so that a
+	// compound assignment such as a[i] += v can read a[i] before storing.
+	OpDup2
 )
 
 type Definition struct {
@@ -131,6 +200,46 @@ var definitions = map[Opcode]*Definition{
 	OpClosure:        {"OpClosure", []int{2, 1}},
 	OpGetFree:        {"OpGetFree", []int{1}},
 	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	OpMakeCell:       {"OpMakeCell", []int{1}},
+	OpGetLocalCell:   {"OpGetLocalCell", []int{1}},
+	OpSetLocalCell:   {"OpSetLocalCell", []int{1}},
+	OpGetFreeCell:    {"OpGetFreeCell", []int{1}},
+	OpSetFreeCell:    {"OpSetFreeCell", []int{1}},
+	OpSetIndex:       {"OpSetIndex", []int{}},
+	OpGetIter:        {"OpGetIter", []int{}},
+	OpIterNext:       {"OpIterNext", []int{2}},
+
+	OpJumpTruthyOrPop:    {"OpJumpTruthyOrPop", []int{2}},
+	OpJumpNotTruthyOrPop: {"OpJumpNotTruthyOrPop", []int{2}},
+	OpMod:                {"OpMod", []int{}},
+	OpPow:                {"OpPow", []int{}},
+	OpBitAnd:             {"OpBitAnd", []int{}},
+	OpBitOr:              {"OpBitOr", []int{}},
+	OpBitXor:             {"OpBitXor", []int{}},
+	OpShiftLeft:          {"OpShiftLeft", []int{}},
+	OpShiftRight:         {"OpShiftRight", []int{}},
+	OpBitNot:             {"OpBitNot", []int{}},
+	OpGreaterThanOrEqual: {"OpGreaterThanOrEqual", []int{}},
+	OpConcat:             {"OpConcat", []int{2}},
+	OpJumpTruthy:         {"OpJumpTruthy", []int{2}},
+	OpTailCall:           {"OpTailCall", []int{1}},
+	OpGetLocalConstAdd:   {"OpGetLocalConstAdd", []int{1, 2}},
+	OpGetLocalConstSub:   {"OpGetLocalConstSub", []int{1, 2}},
+	OpCompareJump:        {"OpCompareJump", []int{2, 1}},
+	OpCall1:              {"OpCall1", []int{}},
+	OpThrow:              {"OpThrow", []int{}},
+	OpDup2:               {"OpDup2", []int{}},
+}
+
+// IsJump reports whether the first operand of op is an instruction offset
+// that execution may continue at.
+func IsJump(op Opcode) bool {
+	switch op {
+	case OpJump, OpJumpNotTruthy, OpJumpTruthy, OpIterNext,
+		OpJumpTruthyOrPop, OpJumpNotTruthyOrPop, OpCompareJump:
+		return true
+	}
+	return false
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -187,6 +296,30 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 	return operands, offset
 }
 
+// Walk calls f with the offset, definition and operands of every
+// instruction in ins. It fails at the first undefined opcode or instruction
+// whose operands run past the end of ins, so that f only ever sees complete
+// instructions.
+func Walk(ins Instructions, f func(offset int, def *Definition, operands []int)) error {
+	for i := 0; i < len(ins); {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			return fmt.Errorf("%04d: %w", i, err)
+		}
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+		if i+1+width > len(ins) {
+			return fmt.Errorf("%04d: %s: truncated operands", i, def.Name)
+		}
+		operands, read := ReadOperands(def, ins[i+1:])
+		f(i, def, operands)
+		i += 1 + read
+	}
+	return nil
+}
+
 func ReadUint16(ins Instructions) uint16 {
 	return binary.BigEndian.Uint16(ins)
 }
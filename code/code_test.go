@@ -1,6 +1,9 @@
 package code
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestMake(t *testing.T) {
 	tests := []struct {
@@ -89,3 +92,189 @@ func TestReadOperands(t *testing.T) {
 		}
 	}
 }
+
+func TestLineTableLineFor(t *testing.T) {
+	lines := LineTable{
+		{Offset: 0, Line: 1},
+		{Offset: 6, Line: 2},
+		{Offset: 10, Line: 4},
+	}
+
+	tests := []struct {
+		offset   int
+		expected int
+	}{
+		{0, 1},
+		{5, 1},
+		{6, 2},
+		{9, 2},
+		{10, 4},
+		{100, 4},
+	}
+
+	for _, tt := range tests {
+		if line := lines.LineFor(tt.offset); line != tt.expected {
+			t.Errorf("wrong line for offset %d. want=%d, got=%d",
+				tt.offset, tt.expected, line)
+		}
+	}
+
+	if line := (LineTable{}).LineFor(0); line != 0 {
+		t.Errorf("empty table returned line %d", line)
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	fn := FunctionInfo{
+		Name: "double",
+		Instructions: concatInstructions(
+			Make(OpGetLocal, 0),
+			Make(OpGetLocal, 0),
+			Make(OpAdd),
+			Make(OpReturnValue),
+		),
+		Lines:         LineTable{{Offset: 0, Line: 2}},
+		NumLocals:     1,
+		NumParameters: 1,
+	}
+	main := FunctionInfo{
+		Instructions: concatInstructions(
+			Make(OpClosure, 1, 0),
+			Make(OpSetGlobal, 0),
+			Make(OpTrue),
+			Make(OpJumpNotTruthy, 17),
+			Make(OpConstant, 0),
+			Make(OpPop),
+			Make(OpNull),
+			Make(OpPop),
+		),
+		Lines: LineTable{{Offset: 0, Line: 1}, {Offset: 7, Line: 4}},
+	}
+	constants := []Constant{
+		{Value: `"x"`},
+		{Value: "fn double", Function: &fn},
+	}
+	source := "let double = fn(x) {\n  x + x\n};\nif (true) { \"x\" }"
+
+	expected := `== main (locals 0, params 0, free 0) ==
+        1 | let double = fn(x) {
+0000 OpClosure 1 0            ; fn double
+0004 OpSetGlobal 0
+        4 | if (true) { "x" }
+0007 OpTrue
+0008 OpJumpNotTruthy L0
+0011 OpConstant 0             ; "x"
+0014 OpPop
+0015 OpNull
+0016 OpPop
+L0:
+
+== constant 1: fn double (locals 1, params 1, free 0) ==
+        2 | x + x
+0000 OpGetLocal 0
+0002 OpGetLocal 0
+0004 OpAdd
+0005 OpReturnValue
+
+`
+
+	var out strings.Builder
+	if err := Disassemble(&out, main, constants, source); err != nil {
+		t.Fatalf("Disassemble returned error: %s", err)
+	}
+	if out.String() != expected {
+		t.Errorf("wrong listing.\nwant=\n%s\ngot=\n%s", expected, out.String())
+	}
+
+	out.Reset()
+	if err := Disassemble(&out, main, constants, ""); err != nil {
+		t.Fatalf("Disassemble returned error: %s", err)
+	}
+	if strings.Contains(out.String(), " | ") {
+		t.Errorf("listing without source contains source lines:\n%s", out.String())
+	}
+}
+
+func TestDisassembleHandlers(t *testing.T) {
+	main := FunctionInfo{
+		Instructions: concatInstructions(
+			Make(OpConstant, 0),
+			Make(OpThrow),
+			Make(OpJump, 8),
+			Make(OpPop),
+			Make(OpNull),
+			Make(OpPop),
+		),
+		Handlers: HandlerTable{{Start: 0, End: 4, Target: 7, StackDepth: 1}},
+	}
+
+	expected := `== main (locals 0, params 0, free 0) ==
+handler 0000-0004 -> L0 (stack 1)
+0000 OpConstant 0             ; 1
+0003 OpThrow
+0004 OpJump L1
+L0:
+0007 OpPop
+L1:
+0008 OpNull
+0009 OpPop
+
+`
+
+	var out strings.Builder
+	if err := Disassemble(&out, main, []Constant{{Value: "1"}}, ""); err != nil {
+		t.Fatalf("Disassemble returned error: %s", err)
+	}
+	if out.String() != expected {
+		t.Errorf("wrong listing.\nwant=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
+
+func TestDisassembleErrors(t *testing.T) {
+	fn := FunctionInfo{Instructions: Instructions{byte(OpGetLocal)}}
+	tests := []struct {
+		main      FunctionInfo
+		constants []Constant
+		expected  string
+	}{
+		{
+			FunctionInfo{Instructions: Instructions{byte(OpConstant)}},
+			nil,
+			"main: 0000: OpConstant: truncated operands",
+		},
+		{
+			FunctionInfo{Instructions: concatInstructions(Make(OpNull), Make(OpJump, 1)[:2])},
+			nil,
+			"main: 0001: OpJump: truncated operands",
+		},
+		{
+			FunctionInfo{Instructions: Instructions{byte(OpPop), 255}},
+			nil,
+			"main: 0001: opcode 255 undefied",
+		},
+		{
+			FunctionInfo{Instructions: Make(OpNull)},
+			[]Constant{{Value: "1"}, {Value: "fn", Function: &fn}},
+			"constant 1: 0000: OpGetLocal: truncated operands",
+		},
+	}
+
+	for _, tt := range tests {
+		var out strings.Builder
+		err := Disassemble(&out, tt.main, tt.constants, "")
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+		if out.Len() != 0 {
+			t.Errorf("output written for invalid instructions:\n%s", out.String())
+		}
+	}
+}
+
+func concatInstructions(parts ...[]byte) Instructions {
+	out := Instructions{}
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
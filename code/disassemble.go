@@ -0,0 +1,164 @@
+package code
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// FunctionInfo describes a compiled function for Disassemble.
+type FunctionInfo struct {
+	Name          string
+	Instructions  Instructions
+	Lines         LineTable
+	Handlers      HandlerTable
+	NumLocals     int
+	NumParameters int
+}
+
+// Constant describes an entry of the constant pool for Disassemble. Value
+// is shown next to the instructions referring to the constant and Function
+// is set when the constant is a compiled function.
+type Constant struct {
+	Value    string
+	Function *FunctionInfo
+}
+
+// Disassemble writes a listing of main followed by every function in
+// constants to w. OpConstant and OpClosure operands are annotated with the
+// constant they refer to, jump targets are replaced by labels and handler
+// tables are listed under the function they belong to. When
+// source is not empty, the source lines recorded in the line tables are
+// interleaved with the instructions generated for them. Nothing is written
+// if an undefined opcode or truncated operands are found.
+func Disassemble(w io.Writer, main FunctionInfo, constants []Constant, source string) error {
+	d := &disassembler{
+		w:         bufio.NewWriter(w),
+		constants: constants,
+		free:      map[int]int{},
+	}
+	if source != "" {
+		d.source = strings.Split(source, "\n")
+	}
+
+	if err := d.collectFree(main.Instructions); err != nil {
+		return fmt.Errorf("main: %w", err)
+	}
+	for i, c := range constants {
+		if c.Function != nil {
+			if err := d.collectFree(c.Function.Instructions); err != nil {
+				return fmt.Errorf("constant %d: %w", i, err)
+			}
+		}
+	}
+
+	d.function("main", main, 0)
+	for i, c := range constants {
+		if c.Function != nil {
+			d.function(fmt.Sprintf("constant %d: %s", i, c.Value), *c.Function, d.free[i])
+		}
+	}
+	return d.w.Flush()
+}
+
+type disassembler struct {
+	w         *bufio.Writer
+	constants []Constant
+	source    []string
+
+	// free maps the index of a function constant to the number of free
+	// variables its closures capture, as given by OpClosure.
+	free map[int]int
+}
+
+// collectFree also checks that ins decodes, so that function and
+// jumpLabels can ignore the errors of Walk.
+func (d *disassembler) collectFree(ins Instructions) error {
+	return Walk(ins, func(offset int, def *Definition, operands []int) {
+		if Opcode(ins[offset]) == OpClosure {
+			d.free[operands[0]] = operands[1]
+		}
+	})
+}
+
+func (d *disassembler) function(title string, fn FunctionInfo, numFree int) {
+	fmt.Fprintf(d.w, "== %s (locals %d, params %d, free %d) ==\n",
+		title, fn.NumLocals, fn.NumParameters, numFree)
+
+	labels := jumpLabels(fn.Instructions, fn.Handlers)
+	for _, h := range fn.Handlers {
+		fmt.Fprintf(d.w, "handler %04d-%04d -> %s (stack %d)\n", h.Start, h.End, labels[h.Target], h.StackDepth)
+	}
+	lastLine := 0
+	Walk(fn.Instructions, func(offset int, def *Definition, operands []int) {
+		if line := fn.Lines.LineFor(offset); line != lastLine {
+			lastLine = line
+			if line > 0 && line <= len(d.source) {
+				fmt.Fprintf(d.w, "%9d | %s\n", line, strings.TrimSpace(d.source[line-1]))
+			}
+		}
+		if label, ok := labels[offset]; ok {
+			fmt.Fprintf(d.w, "%s:\n", label)
+		}
+		fmt.Fprintf(d.w, "%04d %s\n", offset, d.instruction(Opcode(fn.Instructions[offset]), def, operands, labels))
+	})
+	if label, ok := labels[len(fn.Instructions)]; ok {
+		fmt.Fprintf(d.w, "%s:\n", label)
+	}
+	fmt.Fprintln(d.w)
+}
+
+func (d *disassembler) instruction(op Opcode, def *Definition, operands []int, labels map[int]string) string {
+	switch {
+	case op == OpCompareJump:
+		cmp, err := Lookup(byte(operands[1]))
+		if err != nil {
+			return fmt.Sprintf("%s %s %d", def.Name, labels[operands[0]], operands[1])
+		}
+		return fmt.Sprintf("%s %s %s", def.Name, labels[operands[0]], cmp.Name)
+	case IsJump(op):
+		return fmt.Sprintf("%s %s", def.Name, labels[operands[0]])
+	case op == OpConstant || op == OpClosure:
+		text := Instructions(nil).fmtInstruction(def, operands)
+		if operands[0] < len(d.constants) {
+			return fmt.Sprintf("%-24s ; %s", text, d.constants[operands[0]].Value)
+		}
+		return text
+	case op == OpGetLocalConstAdd || op == OpGetLocalConstSub:
+		text := Instructions(nil).fmtInstruction(def, operands)
+		if operands[1] < len(d.constants) {
+			return fmt.Sprintf("%-24s ; %s", text, d.constants[operands[1]].Value)
+		}
+		return text
+	default:
+		return Instructions(nil).fmtInstruction(def, operands)
+	}
+}
+
+// jumpLabels names the targets of the jumps in ins and of handlers L0, L1,
+// ... in the order they appear.
+func jumpLabels(ins Instructions, handlers HandlerTable) map[int]string {
+	var targets []int
+	seen := map[int]bool{}
+	Walk(ins, func(offset int, def *Definition, operands []int) {
+		if IsJump(Opcode(ins[offset])) && !seen[operands[0]] {
+			seen[operands[0]] = true
+			targets = append(targets, operands[0])
+		}
+	})
+	for _, h := range handlers {
+		if !seen[h.Target] {
+			seen[h.Target] = true
+			targets = append(targets, h.Target)
+		}
+	}
+	sort.Ints(targets)
+
+	labels := make(map[int]string, len(targets))
+	for i, target := range targets {
+		labels[target] = fmt.Sprintf("L%d", i)
+	}
+	return labels
+}
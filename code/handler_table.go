@@ -0,0 +1,32 @@
+package code
+
+// Handler is an entry of the exception handler table of a function. An
+// exception raised by an instruction at an offset in [Start, End) resumes
+// execution at Target, with the stack cut back to StackDepth values above
+// the locals of the function and the exception pushed on top.
+type Handler struct {
+	Start      int
+	End        int
+	Target     int
+	StackDepth int
+}
+
+// HandlerTable lists the handlers of a function, innermost first, so that
+// the first entry covering an offset is the one that applies.
+type HandlerTable []Handler
+
+// Lookup returns the handler for an exception raised at offset.
+func (ht HandlerTable) Lookup(offset int) (Handler, bool) {
+	for _, h := range ht {
+		if h.Start <= offset && offset < h.End {
+			return h, true
+		}
+	}
+	return Handler{}, false
+}
+
+// Covers reports whether an exception raised at offset has a handler.
+func (ht HandlerTable) Covers(offset int) bool {
+	_, ok := ht.Lookup(offset)
+	return ok
+}
@@ -0,0 +1,26 @@
+package code
+
+import "sort"
+
+// LineEntry marks the instruction at Offset as the first one generated for
+// source line Line.
+type LineEntry struct {
+	Offset int
+	Line   int
+}
+
+// LineTable maps instruction offsets back to source lines. Entries are
+// sorted by Offset and each one covers the instructions up to the next.
+type LineTable []LineEntry
+
+// LineFor returns the source line of the instruction at offset, or 0 when
+// the table has no information for it.
+func (lt LineTable) LineFor(offset int) int {
+	i := sort.Search(len(lt), func(i int) bool {
+		return lt[i].Offset > offset
+	})
+	if i == 0 {
+		return 0
+	}
+	return lt[i-1].Line
+}
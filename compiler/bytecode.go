@@ -0,0 +1,371 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"monkey/code"
+	"monkey/object"
+	"strconv"
+)
+
+// Serialized bytecode starts with bytecodeMagic followed by the format
+// version as a big-endian uint16. The rest is a sequence of uvarint-prefixed
+// fields: the main instructions, line table and handler table, then the
+// constant pool.
+// Each constant is a tag byte followed by its value.
+var bytecodeMagic = []byte("MKC\x00")
+
+const BytecodeVersion = 3
+
+const (
+	tagInteger byte = iota + 1
+	tagFloat
+	tagString
+	tagCompiledFunction
+)
+
+var errTruncated = errors.New("bytecode is truncated")
+
+// constantKey returns a key identifying the value of obj, used to intern
+// constants. Only values that can be serialized have one; two of them are
+// interchangeable in the constant pool exactly when their keys are equal.
+func constantKey(obj object.Object) (string, bool) {
+	var w bytecodeWriter
+	if err := w.writeConstant(obj); err != nil {
+		return "", false
+	}
+	return string(w.buf), true
+}
+
+// IsBytecode reports whether data starts like serialized bytecode.
+func IsBytecode(data []byte) bool {
+	return bytes.HasPrefix(data, bytecodeMagic)
+}
+
+// MarshalBinary encodes b, including debug info, in the .mkc format.
+func (b *Bytecode) MarshalBinary() ([]byte, error) {
+	var w bytecodeWriter
+	w.buf = append(w.buf, bytecodeMagic...)
+	w.buf = binary.BigEndian.AppendUint16(w.buf, BytecodeVersion)
+
+	w.writeBytes(b.Instructions)
+	w.writeLines(b.Lines)
+	w.writeHandlers(b.Handlers)
+
+	w.writeUint(len(b.Constants))
+	for i, constant := range b.Constants {
+		if err := w.writeConstant(constant); err != nil {
+			return nil, fmt.Errorf("constant %d: %w", i, err)
+		}
+	}
+
+	return w.buf, nil
+}
+
+// UnmarshalBinary decodes bytecode written by MarshalBinary into b.
+func (b *Bytecode) UnmarshalBinary(data []byte) error {
+	if !IsBytecode(data) {
+		return errors.New("not a Monkey bytecode file")
+	}
+	data = data[len(bytecodeMagic):]
+	if len(data) < 2 {
+		return errTruncated
+	}
+	if version := binary.BigEndian.Uint16(data); version != BytecodeVersion {
+		return fmt.Errorf("unsupported bytecode version %d, want %d",
+			version, BytecodeVersion)
+	}
+
+	r := bytecodeReader{data: data[2:]}
+	instructions := code.Instructions(r.readBytes())
+	lines := r.readLines()
+	handlers := r.readHandlers()
+
+	numConstants := r.readUint()
+	if r.err != nil {
+		return r.err
+	}
+	constants := make([]object.Object, 0, min(numConstants, len(r.data)))
+	for i := 0; i < numConstants; i++ {
+		constant, err := r.readConstant()
+		if err != nil {
+			return fmt.Errorf("constant %d: %w", i, err)
+		}
+		constants = append(constants, constant)
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.data) != 0 {
+		return fmt.Errorf("%d bytes of trailing data after bytecode", len(r.data))
+	}
+
+	if err := validateCode(instructions, handlers, len(constants)); err != nil {
+		return fmt.Errorf("main: %w", err)
+	}
+	for i, constant := range constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			if err := validateCode(fn.Instructions, fn.Handlers, len(constants)); err != nil {
+				return fmt.Errorf("constant %d: %w", i, err)
+			}
+		}
+	}
+
+	b.Instructions = instructions
+	b.Lines = lines
+	b.Handlers = handlers
+	b.Constants = constants
+	return nil
+}
+
+// validateCode checks what the vm relies on without checking it itself:
+// that ins decodes, that its constant indexes are in the pool, that its
+// jumps land on an instruction or at the end and that the handlers cover
+// and target ranges of ins.
+func validateCode(ins code.Instructions, handlers code.HandlerTable, numConstants int) error {
+	starts := map[int]bool{len(ins): true}
+	var jumps [][2]int
+	var err error
+	walkErr := code.Walk(ins, func(offset int, def *code.Definition, operands []int) {
+		starts[offset] = true
+		op := code.Opcode(ins[offset])
+		if code.IsJump(op) {
+			jumps = append(jumps, [2]int{offset, operands[0]})
+		}
+
+		index := -1
+		switch op {
+		case code.OpConstant, code.OpClosure:
+			index = operands[0]
+		case code.OpGetLocalConstAdd, code.OpGetLocalConstSub:
+			index = operands[1]
+		}
+		if index >= numConstants && err == nil {
+			err = fmt.Errorf("%04d: %s: constant %d out of range", offset, def.Name, index)
+		}
+	})
+	if walkErr != nil {
+		return walkErr
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, jump := range jumps {
+		if !starts[jump[1]] {
+			return fmt.Errorf("%04d: jump to %d is not an instruction", jump[0], jump[1])
+		}
+	}
+	for _, h := range handlers {
+		if h.Start > h.End || h.End > len(ins) || !starts[h.Target] {
+			return fmt.Errorf("bad handler %d-%d -> %d", h.Start, h.End, h.Target)
+		}
+	}
+	return nil
+}
+
+// Disassemble writes a listing of b to w using code.Disassemble. source,
+// if not empty, is the program b was compiled from.
+func (b *Bytecode) Disassemble(w io.Writer, source string) error {
+	constants := make([]code.Constant, len(b.Constants))
+	for i, obj := range b.Constants {
+		switch obj := obj.(type) {
+		case *object.String:
+			constants[i] = code.Constant{Value: strconv.Quote(obj.Value)}
+		case *object.CompiledFunction:
+			name := "fn"
+			if obj.Name != "" {
+				name += " " + obj.Name
+			}
+			constants[i] = code.Constant{
+				Value: name,
+				Function: &code.FunctionInfo{
+					Name:          obj.Name,
+					Instructions:  obj.Instructions,
+					Lines:         obj.Lines,
+					Handlers:      obj.Handlers,
+					NumLocals:     obj.NumLocals,
+					NumParameters: obj.NumParameters,
+				},
+			}
+		default:
+			constants[i] = code.Constant{Value: obj.Inspect()}
+		}
+	}
+
+	main := code.FunctionInfo{Instructions: b.Instructions, Lines: b.Lines, Handlers: b.Handlers}
+	return code.Disassemble(w, main, constants, source)
+}
+
+type bytecodeWriter struct {
+	buf []byte
+}
+
+func (w *bytecodeWriter) writeUint(n int) {
+	w.buf = binary.AppendUvarint(w.buf, uint64(n))
+}
+
+func (w *bytecodeWriter) writeBytes(b []byte) {
+	w.writeUint(len(b))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *bytecodeWriter) writeLines(lines code.LineTable) {
+	w.writeUint(len(lines))
+	for _, entry := range lines {
+		w.writeUint(entry.Offset)
+		w.writeUint(entry.Line)
+	}
+}
+
+func (w *bytecodeWriter) writeHandlers(handlers code.HandlerTable) {
+	w.writeUint(len(handlers))
+	for _, h := range handlers {
+		w.writeUint(h.Start)
+		w.writeUint(h.End)
+		w.writeUint(h.Target)
+		w.writeUint(h.StackDepth)
+	}
+}
+
+func (w *bytecodeWriter) writeConstant(obj object.Object) error {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		w.buf = append(w.buf, tagInteger)
+		w.buf = binary.AppendVarint(w.buf, obj.Value)
+	case *object.Float:
+		w.buf = append(w.buf, tagFloat)
+		w.buf = binary.BigEndian.AppendUint64(w.buf, math.Float64bits(obj.Value))
+	case *object.String:
+		w.buf = append(w.buf, tagString)
+		w.writeBytes([]byte(obj.Value))
+	case *object.CompiledFunction:
+		w.buf = append(w.buf, tagCompiledFunction)
+		w.writeBytes(obj.Instructions)
+		w.writeUint(obj.NumLocals)
+		w.writeUint(obj.NumParameters)
+		w.writeBytes([]byte(obj.Name))
+		w.writeLines(obj.Lines)
+		w.writeHandlers(obj.Handlers)
+	default:
+		return fmt.Errorf("cannot serialize %s", obj.Type())
+	}
+	return nil
+}
+
+// bytecodeReader decodes the fields written by bytecodeWriter. The first
+// error is kept in err and makes every later read return a zero value.
+type bytecodeReader struct {
+	data []byte
+	err  error
+}
+
+func (r *bytecodeReader) readUint() int {
+	if r.err != nil {
+		return 0
+	}
+	n, size := binary.Uvarint(r.data)
+	if size <= 0 || n > math.MaxInt32 {
+		r.err = errTruncated
+		return 0
+	}
+	r.data = r.data[size:]
+	return int(n)
+}
+
+func (r *bytecodeReader) readInt() int64 {
+	if r.err != nil {
+		return 0
+	}
+	n, size := binary.Varint(r.data)
+	if size <= 0 {
+		r.err = errTruncated
+		return 0
+	}
+	r.data = r.data[size:]
+	return n
+}
+
+func (r *bytecodeReader) readFixed(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.data) < n {
+		r.err = errTruncated
+		return nil
+	}
+	b := r.data[:n:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *bytecodeReader) readBytes() []byte {
+	return r.readFixed(r.readUint())
+}
+
+func (r *bytecodeReader) readLines() code.LineTable {
+	n := r.readUint()
+	if n == 0 {
+		return nil
+	}
+	lines := make(code.LineTable, 0, min(n, len(r.data)))
+	for i := 0; i < n && r.err == nil; i++ {
+		offset := r.readUint()
+		line := r.readUint()
+		lines = append(lines, code.LineEntry{Offset: offset, Line: line})
+	}
+	return lines
+}
+
+func (r *bytecodeReader) readHandlers() code.HandlerTable {
+	n := r.readUint()
+	if n == 0 {
+		return nil
+	}
+	handlers := make(code.HandlerTable, 0, min(n, len(r.data)))
+	for i := 0; i < n && r.err == nil; i++ {
+		handlers = append(handlers, code.Handler{
+			Start:      r.readUint(),
+			End:        r.readUint(),
+			Target:     r.readUint(),
+			StackDepth: r.readUint(),
+		})
+	}
+	return handlers
+}
+
+func (r *bytecodeReader) readConstant() (object.Object, error) {
+	tag := r.readFixed(1)
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	var obj object.Object
+	switch tag[0] {
+	case tagInteger:
+		obj = &object.Integer{Value: r.readInt()}
+	case tagFloat:
+		bits := r.readFixed(8)
+		if bits != nil {
+			obj = &object.Float{Value: math.Float64frombits(binary.BigEndian.Uint64(bits))}
+		}
+	case tagString:
+		obj = &object.String{Value: string(r.readBytes())}
+	case tagCompiledFunction:
+		obj = &object.CompiledFunction{
+			Instructions:  r.readBytes(),
+			NumLocals:     r.readUint(),
+			NumParameters: r.readUint(),
+			Name:          string(r.readBytes()),
+			Lines:         r.readLines(),
+			Handlers:      r.readHandlers(),
+		}
+	default:
+		return nil, fmt.Errorf("unknown constant tag %d", tag[0])
+	}
+	return obj, r.err
+}
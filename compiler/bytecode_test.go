@@ -0,0 +1,209 @@
+package compiler
+
+import (
+	"bytes"
+	"monkey/code"
+	"monkey/object"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBytecodeRoundTrip(t *testing.T) {
+	input := `
+	let greeting = "hello";
+	let add = fn(a, b) {
+		let c = a + b;
+		c * 1.5
+	};
+	let adder = fn(x) { fn(y) { x + y } };
+	add(-1, 2) + adder(3)(4);
+	let safe = fn(f) { try { f() } finally { puts("done") } };
+	try { safe(adder(1)) } catch (e) { e }
+	let count = fn(n) {
+		let i = 0;
+		while (i < n) { i = i - 1 + 2; if (i == 2) { continue; } }
+		for (x in [1, 2]) { if (x > 1 && true) { break; } }
+		i
+	};
+	count(3);
+	`
+
+	compiler := New()
+	if err := compiler.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	data, err := bytecode.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error: %s", err)
+	}
+	if !IsBytecode(data) {
+		t.Fatalf("serialized bytecode does not start with the magic header")
+	}
+
+	decoded := &Bytecode{}
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary error: %s", err)
+	}
+
+	if err := testInstruction(
+		[]code.Instructions{bytecode.Instructions}, decoded.Instructions); err != nil {
+		t.Fatalf("testInstructions fail: %s", err)
+	}
+	if err := testLineTable(bytecode.Lines, decoded.Lines); err != nil {
+		t.Fatalf("testLineTable fail: %s", err)
+	}
+	if len(bytecode.Handlers) == 0 || !reflect.DeepEqual(bytecode.Handlers, decoded.Handlers) {
+		t.Fatalf("wrong handlers. want=%+v, got=%+v", bytecode.Handlers, decoded.Handlers)
+	}
+
+	expectedConstants := []interface{}{"hello", 1.5}
+	if err := testConstants(t, expectedConstants, decoded.Constants[:2]); err != nil {
+		t.Fatalf("testConstants failed: %s", err)
+	}
+	if len(decoded.Constants) != len(bytecode.Constants) {
+		t.Fatalf("wrong number of constants. want=%d, got=%d",
+			len(bytecode.Constants), len(decoded.Constants))
+	}
+	for i, constant := range bytecode.Constants {
+		if decoded.Constants[i].Type() != constant.Type() {
+			t.Errorf("constant %d has wrong type. want=%s, got=%s",
+				i, constant.Type(), decoded.Constants[i].Type())
+		}
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			decodedFn := decoded.Constants[i].(*object.CompiledFunction)
+			if !reflect.DeepEqual(fn.Handlers, decodedFn.Handlers) {
+				t.Errorf("constant %d has wrong handlers. want=%+v, got=%+v",
+					i, fn.Handlers, decodedFn.Handlers)
+			}
+		}
+	}
+
+	again, err := decoded.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error: %s", err)
+	}
+	if !bytes.Equal(data, again) {
+		t.Errorf("re-encoded bytecode differs from the original")
+	}
+}
+
+func TestBytecodeUnmarshalErrors(t *testing.T) {
+	compiler := New()
+	if err := compiler.Compile(parse(`let f = fn() { "abc" }; f();`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	data, err := compiler.Bytecode().MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error: %s", err)
+	}
+
+	badVersion := append([]byte{}, data...)
+	badVersion[len(bytecodeMagic)+1]++
+
+	tests := []struct {
+		data     []byte
+		expected string
+	}{
+		{[]byte("let x = 1;"), "not a Monkey bytecode file"},
+		{badVersion, "unsupported bytecode version 4, want 3"},
+		{data[:len(bytecodeMagic)+1], "bytecode is truncated"},
+		{data[:len(data)-2], "bytecode is truncated"},
+		{append(append([]byte{}, data...), 0), "1 bytes of trailing data after bytecode"},
+	}
+
+	for _, tt := range tests {
+		err := (&Bytecode{}).UnmarshalBinary(tt.data)
+		if err == nil {
+			t.Errorf("expected error %q but got none", tt.expected)
+			continue
+		}
+		if !strings.HasSuffix(err.Error(), tt.expected) {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestBytecodeUnmarshalInvalidCode(t *testing.T) {
+	fn := func(ins ...code.Instructions) *object.CompiledFunction {
+		return &object.CompiledFunction{Instructions: concatInstructions(ins)}
+	}
+	tests := []struct {
+		bytecode *Bytecode
+		expected string
+	}{
+		{
+			&Bytecode{Instructions: code.Instructions{byte(code.OpConstant)}},
+			"main: 0000: OpConstant: truncated operands",
+		},
+		{
+			&Bytecode{Instructions: code.Instructions{byte(code.OpPop), 255}},
+			"main: 0001: opcode 255 undefied",
+		},
+		{
+			&Bytecode{Instructions: concatInstructions([]code.Instructions{code.Make(code.OpConstant, 1)}),
+				Constants: []object.Object{&object.Integer{Value: 1}}},
+			"main: 0000: OpConstant: constant 1 out of range",
+		},
+		{
+			&Bytecode{Instructions: concatInstructions([]code.Instructions{code.Make(code.OpClosure, 0, 0)})},
+			"main: 0000: OpClosure: constant 0 out of range",
+		},
+		{
+			&Bytecode{Instructions: code.Make(code.OpNull),
+				Constants: []object.Object{fn(code.Make(code.OpGetLocalConstAdd, 0, 1), code.Make(code.OpReturnValue))}},
+			"constant 0: 0000: OpGetLocalConstAdd: constant 1 out of range",
+		},
+		{
+			&Bytecode{Instructions: concatInstructions([]code.Instructions{code.Make(code.OpJump, 5), code.Make(code.OpNull)})},
+			"main: 0000: jump to 5 is not an instruction",
+		},
+		{
+			&Bytecode{Instructions: concatInstructions([]code.Instructions{code.Make(code.OpTrue), code.Make(code.OpJumpNotTruthy, 2)})},
+			"main: 0001: jump to 2 is not an instruction",
+		},
+		{
+			&Bytecode{Instructions: code.Make(code.OpNull),
+				Handlers: code.HandlerTable{{Start: 0, End: 2, Target: 0}}},
+			"main: bad handler 0-2 -> 0",
+		},
+		{
+			&Bytecode{Instructions: concatInstructions([]code.Instructions{code.Make(code.OpNull), code.Make(code.OpPop)}),
+				Handlers: code.HandlerTable{{Start: 1, End: 0, Target: 0}}},
+			"main: bad handler 1-0 -> 0",
+		},
+		{
+			&Bytecode{Instructions: code.Make(code.OpNull),
+				Handlers: code.HandlerTable{{Start: 0, End: 1, Target: 5}}},
+			"main: bad handler 0-1 -> 5",
+		},
+		{
+			&Bytecode{Instructions: code.Make(code.OpNull),
+				Constants: []object.Object{fn(code.Make(code.OpNull), code.Make(code.OpReturnValue))}},
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		data, err := tt.bytecode.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary error: %s", err)
+		}
+		var decoded Bytecode
+		err = decoded.UnmarshalBinary(data)
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+		if decoded.Instructions != nil {
+			t.Errorf("invalid bytecode was stored: %v", decoded.Instructions)
+		}
+	}
+}
@@ -8,19 +8,88 @@ import (
 	"sort"
 )
 
+// MaxConstants is the number of constants the 2-byte operand of OpConstant
+// and OpClosure can address.
+const MaxConstants = 1 << 16
+
 type Compiler struct {
 	constants []object.Object
+	// constantIndex maps the key of every interned constant to its index
+	constantIndex map[string]int
 
 	symbolTable *SymbolTable
 
 	scopes     []CompilationScope
 	scopeIndex int
+
+	// line is the source line of the node being compiled
+	line int
+
+	// optimize enables constant folding, the pruning of branches whose
+	// condition is known at compile time and the peephole pass
+	optimize bool
+
+	// builtins are the builtins defined in the symbol table made by New
+	builtins *object.Registry
+}
+
+// Option configures a Compiler created by New or NewWithState.
+type Option func(*Compiler)
+
+// WithOptimizations turns compile-time optimizations on or off. They are off
+// by default, so that the bytecode follows the source one to one.
+func WithOptimizations(enabled bool) Option {
+	return func(c *Compiler) { c.optimize = enabled }
+}
+
+// WithBuiltins makes the program see the builtins of r instead of the
+// standard ones. The VM running the bytecode has to be given r too. It has
+// no effect on NewWithState, whose symbol table already defines builtins.
+func WithBuiltins(r *object.Registry) Option {
+	return func(c *Compiler) { c.builtins = r }
 }
 
 type CompilationScope struct {
 	instructions        code.Instructions
+	lines               code.LineTable
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+
+	// loops holds the loops enclosing the code being compiled, innermost last
+	loops []*loopContext
+	// tries holds the try statements enclosing the code being compiled,
+	// innermost last
+	tries []*tryContext
+	// handlers is the exception handler table of the code compiled so far
+	handlers code.HandlerTable
+	// stack is the number of values that enclosing expressions and loops keep
+	// on the stack, above the locals, while the current node runs
+	stack int
+}
+
+type loopContext struct {
+	// continuePos is where a continue statement jumps to
+	continuePos int
+	// breakJumps are the positions of the jumps out of the loop, patched
+	// once the end of the loop is known
+	breakJumps []int
+	// hasIterator is set for for-in loops, which keep their iterator on the
+	// stack; break has to pop it
+	hasIterator bool
+	// stack is the number of values on the stack when the loop starts, below
+	// its iterator. break and continue pop whatever enclosing expressions
+	// in the body left above it.
+	stack int
+	// tries is the number of try statements enclosing the loop; break and
+	// continue run the finally blocks of the ones inside it
+	tries int
+}
+
+type tryContext struct {
+	finally *ast.BlockStatement
+	// gaps are the ranges of code leaving the try early, such as the copies
+	// of finally blocks run by return, which its handlers do not cover
+	gaps [][2]int
 }
 
 type EmittedInstruction struct {
@@ -28,34 +97,53 @@ type EmittedInstruction struct {
 	Position int
 }
 
-func New() *Compiler {
+func New(opts ...Option) *Compiler {
 	mainScope := CompilationScope{
 		instructions:        code.Instructions{},
 		lastInstruction:     EmittedInstruction{},
 		previousInstruction: EmittedInstruction{},
 	}
-	symbolTable := NewSymbolTable()
-
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
+	c := &Compiler{
+		constants:     []object.Object{},
+		constantIndex: map[string]int{},
+		symbolTable:   NewSymbolTable(),
+		scopes:        []CompilationScope{mainScope},
+		scopeIndex:    0,
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
 
-	return &Compiler{
-		constants:   []object.Object{},
-		symbolTable: symbolTable,
-		scopes:      []CompilationScope{mainScope},
-		scopeIndex:  0,
+	if c.builtins == nil {
+		c.builtins = object.NewDefaultRegistry()
 	}
+	for i, def := range c.builtins.Definitions() {
+		c.symbolTable.DefineBuiltin(i, def.Name)
+	}
+	return c
 }
 
-func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
-	compiler := New()
+func NewWithState(s *SymbolTable, constants []object.Object, opts ...Option) *Compiler {
+	compiler := New(opts...)
 	compiler.symbolTable = s
 	compiler.constants = constants
+	for i, obj := range constants {
+		if key, ok := constantKey(obj); ok {
+			compiler.constantIndex[key] = i
+		}
+	}
 	return compiler
 }
 
 func (c *Compiler) Compile(node ast.Node) error {
+	if node != nil {
+		if pos := node.Pos(); pos.IsValid() {
+			line := c.line
+			c.line = pos.Line
+			defer func() { c.line = line }()
+		}
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
 		for _, s := range node.Statements {
@@ -69,21 +157,33 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		c.emit(code.OpPop)
 	case *ast.InfixExpression:
-		if node.Operator == "<" {
+		if c.optimize {
+			if obj, ok := fold(node); ok {
+				return c.emitFolded(node, obj)
+			}
+		}
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogicalExpression(node)
+		}
+		if node.Operator == "<" || node.Operator == "<=" {
 			if err := c.Compile(node.Right); err != nil {
 				return err
 			}
-			if err := c.Compile(node.Left); err != nil {
+			if err := c.compileAbove(1, node.Left); err != nil {
 				return err
 			}
-			c.emit(code.OpGreaterThan)
+			if node.Operator == "<" {
+				c.emit(code.OpGreaterThan)
+			} else {
+				c.emit(code.OpGreaterThanOrEqual)
+			}
 			return nil
 		}
 
 		if err := c.Compile(node.Left); err != nil {
 			return err
 		}
-		if err := c.Compile(node.Right); err != nil {
+		if err := c.compileAbove(1, node.Right); err != nil {
 			return err
 		}
 		switch node.Operator {
@@ -95,16 +195,37 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpMul)
 		case "/":
 			c.emit(code.OpDiv)
+		case "%":
+			c.emit(code.OpMod)
+		case "**":
+			c.emit(code.OpPow)
+		case "&":
+			c.emit(code.OpBitAnd)
+		case "|":
+			c.emit(code.OpBitOr)
+		case "^":
+			c.emit(code.OpBitXor)
+		case "<<":
+			c.emit(code.OpShiftLeft)
+		case ">>":
+			c.emit(code.OpShiftRight)
 		case ">":
 			c.emit(code.OpGreaterThan)
+		case ">=":
+			c.emit(code.OpGreaterThanOrEqual)
 		case "==":
 			c.emit(code.OpEqual)
 		case "!=":
 			c.emit(code.OpNotEqual)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
 		}
 	case *ast.PrefixExpression:
+		if c.optimize {
+			if obj, ok := fold(node); ok {
+				return c.emitFolded(node, obj)
+			}
+		}
 		if err := c.Compile(node.Right); err != nil {
 			return err
 		}
@@ -113,20 +234,24 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpBang)
 		case "-":
 			c.emit(code.OpMinus)
+		case "~":
+			c.emit(code.OpBitNot)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
 		}
 	case *ast.IfExpression:
+		if c.optimize {
+			if cond, ok := fold(node.Condition); ok {
+				return c.compilePrunedIf(node, constantTruthy(cond))
+			}
+		}
 		if err := c.Compile(node.Condition); err != nil {
 			return err
 		}
 		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
-		if err := c.Compile(node.Consequence); err != nil {
+		if err := c.compileBranch(node.Consequence); err != nil {
 			return err
 		}
-		if c.lastInstructionIs(code.OpPop) {
-			c.removeLastPop()
-		}
 		jumpPos := c.emit(code.OpJump, 9999)
 
 		afterConsequencePos := len(c.currentInstructions())
@@ -134,30 +259,32 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if node.Alternative == nil {
 			c.emit(code.OpNull)
 		} else {
-			if err := c.Compile(node.Alternative); err != nil {
+			if err := c.compileBranch(node.Alternative); err != nil {
 				return err
 			}
-
-			if c.lastInstructionIs(code.OpPop) {
-				c.removeLastPop()
-			}
 		}
 		afterAlternativePos := len(c.currentInstructions())
 		c.changeOperand(jumpPos, afterAlternativePos)
 	case *ast.LetStatement:
 		symbol := c.symbolTable.Define(node.Name.Value)
+		if symbol.Cell && assignsOwnName(node.Value) {
+			// the closure captures the cell of its own variable, which
+			// has to exist before the closure does
+			c.emit(code.OpNull)
+			c.storeDefinition(symbol)
+			if err := c.Compile(node.Value); err != nil {
+				return err
+			}
+			return c.storeSymbol(symbol)
+		}
 		if err := c.Compile(node.Value); err != nil {
 			return err
 		}
-		if symbol.Scope == GlobalScope {
-			c.emit(code.OpSetGlobal, symbol.Index)
-		} else {
-			c.emit(code.OpSetLocal, symbol.Index)
-		}
+		c.storeDefinition(symbol)
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Value)
+			return fmt.Errorf("%s: undefined variable %s", node.Pos(), node.Value)
 		}
 		c.loadSymbol(symbol)
 	case *ast.BlockStatement:
@@ -167,8 +294,8 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 	case *ast.ArrayLiteral:
-		for _, ele := range node.Elements {
-			if err := c.Compile(ele); err != nil {
+		for i, ele := range node.Elements {
+			if err := c.compileAbove(i, ele); err != nil {
 				return err
 			}
 		}
@@ -181,11 +308,11 @@ func (c *Compiler) Compile(node ast.Node) error {
 		sort.Slice(keys, func(i, j int) bool {
 			return keys[i].String() < keys[j].String()
 		})
-		for _, k := range keys {
-			if err := c.Compile(k); err != nil {
+		for i, k := range keys {
+			if err := c.compileAbove(2*i, k); err != nil {
 				return err
 			}
-			if err := c.Compile(node.Pairs[k]); err != nil {
+			if err := c.compileAbove(2*i+1, node.Pairs[k]); err != nil {
 				return err
 			}
 		}
@@ -194,18 +321,22 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if err := c.Compile(node.Left); err != nil {
 			return err
 		}
-		if err := c.Compile(node.Index); err != nil {
+		if err := c.compileAbove(1, node.Index); err != nil {
 			return err
 		}
 		c.emit(code.OpIndex)
 	case *ast.FunctionLiteral:
 		c.enterScope()
+		c.symbolTable.cells = cellVariables(node)
 
-		if node.Name != "" {
+		if node.Name != "" && !assignsOwnName(node) {
 			c.symbolTable.DefineFunctionName(node.Name)
 		}
 		for _, parameter := range node.Parameters {
-			c.symbolTable.Define(parameter.Value)
+			symbol := c.symbolTable.Define(parameter.Value)
+			if symbol.Cell {
+				c.emit(code.OpMakeCell, symbol.Index)
+			}
 		}
 		if err := c.Compile(node.Body); err != nil {
 			return err
@@ -218,35 +349,87 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		freeSymbols := c.symbolTable.FreeSymbols
 		numLocals := c.symbolTable.numDefinitions
+		lines := c.scopes[c.scopeIndex].lines
+		handlers := c.scopes[c.scopeIndex].handlers
 		instructions := c.leaveScope()
+		markTailCalls(instructions, handlers)
+		if c.optimize {
+			instructions, lines, handlers = optimizeInstructions(instructions, lines, handlers)
+		}
 
 		for _, s := range freeSymbols {
-			c.loadSymbol(s)
+			c.loadCapture(s)
 		}
 
 		compiledFn := &object.CompiledFunction{
 			Instructions:  instructions,
 			NumLocals:     numLocals,
 			NumParameters: len(node.Parameters),
+			Name:          node.Name,
+			Lines:         lines,
+			Handlers:      handlers,
+		}
+		fnIndex, err := c.addConstant(compiledFn)
+		if err != nil {
+			return fmt.Errorf("%s: %w", node.Pos(), err)
 		}
-		fnIndex := c.addConstant(compiledFn)
 		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
 	case *ast.ReturnStatement:
 		if err := c.Compile(node.ReturnValue); err != nil {
 			return err
 		}
+		if err := c.leaveTries(0, 1); err != nil {
+			return err
+		}
 
 		c.emit(code.OpReturnValue)
 	case *ast.CallExpression:
 		if err := c.Compile(node.Function); err != nil {
 			return err
 		}
-		for _, argument := range node.Arguments {
-			if err := c.Compile(argument); err != nil {
+		for i, argument := range node.Arguments {
+			if err := c.compileAbove(i+1, argument); err != nil {
 				return err
 			}
 		}
 		c.emit(code.OpCall, len(node.Arguments))
+	case *ast.AssignExpression:
+		return c.compileAssignExpression(node)
+	case *ast.WhileStatement:
+		return c.compileWhileStatement(node)
+	case *ast.ForStatement:
+		return c.compileForStatement(node)
+	case *ast.TryStatement:
+		return c.compileTryStatement(node)
+	case *ast.ThrowStatement:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+	case *ast.BreakStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return fmt.Errorf("%s: break outside of loop", node.Pos())
+		}
+		if err := c.leaveTries(loop.tries, 0); err != nil {
+			return err
+		}
+		c.popAbove(loop.stack)
+		loop.breakJumps = append(loop.breakJumps, c.emit(code.OpJump, 9999))
+	case *ast.ContinueStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return fmt.Errorf("%s: continue outside of loop", node.Pos())
+		}
+		if err := c.leaveTries(loop.tries, 0); err != nil {
+			return err
+		}
+		if loop.hasIterator {
+			c.popAbove(loop.stack + 1)
+		} else {
+			c.popAbove(loop.stack)
+		}
+		c.emit(code.OpJump, loop.continuePos)
 	case *ast.Boolean:
 		if node.Value {
 			c.emit(code.OpTrue)
@@ -255,14 +438,333 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 	case *ast.IntegerLiteral:
 		integer := &object.Integer{Value: node.Value}
-		c.emit(code.OpConstant, c.addConstant(integer))
+		return c.emitConstant(node, integer)
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		return c.emitConstant(node, float)
 	case *ast.StringLiteral:
 		str := &object.String{Value: node.Value}
-		c.emit(code.OpConstant, c.addConstant(str))
+		return c.emitConstant(node, str)
+	case *ast.InterpolatedString:
+		for i, part := range node.Parts {
+			if err := c.compileAbove(i, part); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpConcat, len(node.Parts))
+	}
+	return nil
+}
+
+// compilePrunedIf compiles an if expression whose condition is known to be
+// truthy or not at compile time to just the branch that would be taken. The
+// other branch is still compiled, in source order, and its code thrown away,
+// so that the errors it reports and the names it defines do not depend on
+// whether optimizations are on.
+func (c *Compiler) compilePrunedIf(node *ast.IfExpression, truthy bool) error {
+	if truthy {
+		if err := c.compileBranch(node.Consequence); err != nil {
+			return err
+		}
+		if node.Alternative != nil {
+			return c.compileDiscarded(node.Alternative)
+		}
+		return nil
+	}
+	if err := c.compileDiscarded(node.Consequence); err != nil {
+		return err
+	}
+	if node.Alternative == nil {
+		c.emit(code.OpNull)
+		return nil
+	}
+	return c.compileBranch(node.Alternative)
+}
+
+// compileDiscarded compiles node for the errors it reports and the symbols it
+// defines only. The instructions, handlers and constants it adds are removed
+// again, and so are the jumps it registers with enclosing loops and tries.
+func (c *Compiler) compileDiscarded(node ast.Node) error {
+	saved := c.scopes[c.scopeIndex]
+	constants := len(c.constants)
+	breakJumps := make([]int, len(saved.loops))
+	for i, loop := range saved.loops {
+		breakJumps[i] = len(loop.breakJumps)
+	}
+	gaps := make([]int, len(saved.tries))
+	for i, try := range saved.tries {
+		gaps[i] = len(try.gaps)
+	}
+
+	err := c.Compile(node)
+
+	c.scopes[c.scopeIndex] = saved
+	for i, loop := range saved.loops {
+		loop.breakJumps = loop.breakJumps[:breakJumps[i]]
+	}
+	for i, try := range saved.tries {
+		try.gaps = try.gaps[:gaps[i]]
+	}
+	c.constants = c.constants[:constants]
+	for key, index := range c.constantIndex {
+		if index >= constants {
+			delete(c.constantIndex, key)
+		}
+	}
+	return err
+}
+
+// compileLogicalExpression compiles && and || so that the right operand is
+// only evaluated when the left one does not decide the result. The result is
+// the deciding operand itself.
+func (c *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+
+	jump := code.OpJumpNotTruthyOrPop
+	if node.Operator == "||" {
+		jump = code.OpJumpTruthyOrPop
+	}
+	jumpPos := c.emit(jump, 9999)
+
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// compileBranch compiles a branch of an if expression so that it leaves
+// exactly one value on the stack: that of its last expression statement, or
+// null if it has none.
+func (c *Compiler) compileBranch(block *ast.BlockStatement) error {
+	if err := c.Compile(block); err != nil {
+		return err
+	}
+
+	if len(block.Statements) != 0 && c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
+func (c *Compiler) compileWhileStatement(node *ast.WhileStatement) error {
+	startPos := len(c.currentInstructions())
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	loop := c.enterLoop(startPos, false)
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+	c.emit(code.OpJump, startPos)
+	c.leaveLoop()
+
+	afterLoopPos := len(c.currentInstructions())
+	c.changeOperand(jumpNotTruthyPos, afterLoopPos)
+	for _, pos := range loop.breakJumps {
+		c.changeOperand(pos, afterLoopPos)
+	}
+	return nil
+}
+
+func (c *Compiler) compileForStatement(node *ast.ForStatement) error {
+	if err := c.Compile(node.Iterable); err != nil {
+		return err
+	}
+	c.emit(code.OpGetIter)
+
+	startPos := c.emit(code.OpIterNext, 9999)
+	symbol := c.symbolTable.Define(node.Variable.Value)
+	c.storeDefinition(symbol)
+
+	loop := c.enterLoop(startPos, true)
+	if err := c.compileAbove(1, node.Body); err != nil {
+		return err
+	}
+	c.emit(code.OpJump, startPos)
+	c.leaveLoop()
+
+	afterLoopPos := len(c.currentInstructions())
+	c.changeOperand(startPos, afterLoopPos)
+	for _, pos := range loop.breakJumps {
+		c.changeOperand(pos, afterLoopPos)
+	}
+	return nil
+}
+
+func (c *Compiler) enterLoop(continuePos int, hasIterator bool) *loopContext {
+	loop := &loopContext{
+		continuePos: continuePos,
+		hasIterator: hasIterator,
+		tries:       len(c.scopes[c.scopeIndex].tries),
+		stack:       c.scopes[c.scopeIndex].stack,
+	}
+	c.scopes[c.scopeIndex].loops = append(c.scopes[c.scopeIndex].loops, loop)
+	return loop
+}
+
+func (c *Compiler) leaveLoop() {
+	loops := c.scopes[c.scopeIndex].loops
+	c.scopes[c.scopeIndex].loops = loops[:len(loops)-1]
+}
+
+func (c *Compiler) currentLoop() *loopContext {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
+// compileTryStatement lays out a try statement as its body, followed by the
+// catch block and a copy of the finally block run when the body raises an
+// exception and rethrows it. Every way out of the body and the catch block
+// runs its own copy of the finally block first. The handlers added send
+// exceptions raised in the body to the catch block and those raised in
+// either to the rethrowing copy of the finally block.
+func (c *Compiler) compileTryStatement(node *ast.TryStatement) error {
+	depth := c.scopes[c.scopeIndex].stack
+	try := &tryContext{finally: node.Finally}
+
+	c.enterTry(try)
+	start := len(c.currentInstructions())
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+	end := len(c.currentInstructions())
+	c.leaveTry()
+
+	if node.Finally != nil {
+		if err := c.Compile(node.Finally); err != nil {
+			return err
+		}
+	}
+	afterJumps := []int{c.emit(code.OpJump, 9999)}
+
+	catchStart, catchEnd := 0, 0
+	if node.Catch != nil {
+		catchStart = len(c.currentInstructions())
+		c.addHandler(try, start, end, catchStart, depth)
+		if node.Finally != nil {
+			c.enterTry(try)
+		}
+		if node.Param != nil {
+			c.storeDefinition(c.symbolTable.Define(node.Param.Value))
+		} else {
+			c.emit(code.OpPop)
+		}
+		if err := c.Compile(node.Catch); err != nil {
+			return err
+		}
+		catchEnd = len(c.currentInstructions())
+
+		if node.Finally != nil {
+			c.leaveTry()
+			if err := c.Compile(node.Finally); err != nil {
+				return err
+			}
+		}
+		afterJumps = append(afterJumps, c.emit(code.OpJump, 9999))
+	}
+
+	if node.Finally != nil {
+		target := len(c.currentInstructions())
+		c.addHandler(try, start, end, target, depth)
+		c.addHandler(try, catchStart, catchEnd, target, depth)
+		if err := c.compileAbove(1, node.Finally); err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+	}
+
+	afterPos := len(c.currentInstructions())
+	for _, pos := range afterJumps {
+		c.changeOperand(pos, afterPos)
+	}
+	return nil
+}
+
+func (c *Compiler) enterTry(try *tryContext) {
+	c.scopes[c.scopeIndex].tries = append(c.scopes[c.scopeIndex].tries, try)
+}
+
+func (c *Compiler) leaveTry() {
+	tries := c.scopes[c.scopeIndex].tries
+	c.scopes[c.scopeIndex].tries = tries[:len(tries)-1]
+}
+
+// leaveTries compiles copies of the finally blocks of the try statements
+// enclosing the code being compiled, from the innermost one down to the one
+// at index from, for a statement leaving them early. Each copy runs outside
+// of its own try statement and those inside it, with n more values on the
+// stack.
+func (c *Compiler) leaveTries(from, n int) error {
+	tries := c.scopes[c.scopeIndex].tries
+	defer func() { c.scopes[c.scopeIndex].tries = tries }()
+
+	for i := len(tries) - 1; i >= from; i-- {
+		if tries[i].finally == nil {
+			continue
+		}
+		c.scopes[c.scopeIndex].tries = tries[:i:i]
+		start := len(c.currentInstructions())
+		if err := c.compileAbove(n, tries[i].finally); err != nil {
+			return err
+		}
+		end := len(c.currentInstructions())
+		for _, try := range tries[i:] {
+			try.gaps = append(try.gaps, [2]int{start, end})
+		}
 	}
 	return nil
 }
 
+// addHandler adds a handler sending the exceptions raised in [start, end),
+// outside of the gaps of try, to target with depth values on the stack.
+func (c *Compiler) addHandler(try *tryContext, start, end, target, depth int) {
+	scope := &c.scopes[c.scopeIndex]
+	for _, gap := range try.gaps {
+		if gap[1] <= start || gap[0] >= end {
+			continue
+		}
+		if start < gap[0] {
+			scope.handlers = append(scope.handlers, code.Handler{
+				Start: start, End: gap[0], Target: target, StackDepth: depth,
+			})
+		}
+		start = gap[1]
+	}
+	if start < end {
+		scope.handlers = append(scope.handlers, code.Handler{
+			Start: start, End: end, Target: target, StackDepth: depth,
+		})
+	}
+}
+
+// popAbove emits the pops that bring the stack down to depth values from
+// the number the code being compiled runs with.
+func (c *Compiler) popAbove(depth int) {
+	for i := depth; i < c.scopes[c.scopeIndex].stack; i++ {
+		c.emit(code.OpPop)
+	}
+}
+
+// compileAbove compiles node while the enclosing code keeps n more values on
+// the stack, so that the handlers of try statements within node know how
+// much of the stack to keep.
+func (c *Compiler) compileAbove(n int, node ast.Node) error {
+	scope := c.scopeIndex
+	c.scopes[scope].stack += n
+	defer func() { c.scopes[scope].stack -= n }()
+	return c.Compile(node)
+}
+
 func (c *Compiler) emit(op code.Opcode, operands ...int) int {
 	ins := code.Make(op, operands...)
 	pos := c.addInstruction(ins)
@@ -280,19 +782,64 @@ func (c *Compiler) addInstruction(ins []byte) int {
 	updatedInstruction := append(c.currentInstructions(), ins...)
 
 	c.scopes[c.scopeIndex].instructions = updatedInstruction
+	c.addLine(posNewInstruction)
 
 	return posNewInstruction
 }
 
-func (c *Compiler) addConstant(obj object.Object) int {
+func (c *Compiler) addLine(pos int) {
+	lines := c.scopes[c.scopeIndex].lines
+	if c.line == 0 || (len(lines) > 0 && lines[len(lines)-1].Line == c.line) {
+		return
+	}
+	c.scopes[c.scopeIndex].lines = append(lines, code.LineEntry{Offset: pos, Line: c.line})
+}
+
+// addConstant returns the index of obj in the constant pool. Integers,
+// floats, strings and compiled functions are interned, so adding a value
+// that is already in the pool returns the existing index.
+func (c *Compiler) addConstant(obj object.Object) (int, error) {
+	key, ok := constantKey(obj)
+	if ok {
+		if index, ok := c.constantIndex[key]; ok {
+			return index, nil
+		}
+	}
+	if len(c.constants) >= MaxConstants {
+		return 0, fmt.Errorf("too many constants: the pool is limited to %d", MaxConstants)
+	}
+
 	c.constants = append(c.constants, obj)
-	return len(c.constants) - 1
+	index := len(c.constants) - 1
+	if ok {
+		c.constantIndex[key] = index
+	}
+	return index, nil
+}
+
+// emitConstant adds obj to the constant pool and emits the OpConstant that
+// loads it, reporting a full pool at node.
+func (c *Compiler) emitConstant(node ast.Node, obj object.Object) error {
+	index, err := c.addConstant(obj)
+	if err != nil {
+		return fmt.Errorf("%s: %w", node.Pos(), err)
+	}
+	c.emit(code.OpConstant, index)
+	return nil
 }
 
 func (c *Compiler) Bytecode() *Bytecode {
+	instructions := c.currentInstructions()
+	lines := c.scopes[c.scopeIndex].lines
+	handlers := c.scopes[c.scopeIndex].handlers
+	if c.optimize {
+		instructions, lines, handlers = optimizeInstructions(instructions, lines, handlers)
+	}
 	return &Bytecode{
-		Instructions: c.currentInstructions(),
+		Instructions: instructions,
 		Constants:    c.constants,
+		Lines:        lines,
+		Handlers:     handlers,
 	}
 }
 
@@ -321,6 +868,12 @@ func (c *Compiler) removeLastPop() {
 	newIns := oldIns[:last.Position]
 	c.scopes[c.scopeIndex].instructions = newIns
 	c.scopes[c.scopeIndex].lastInstruction = previous
+
+	lines := c.scopes[c.scopeIndex].lines
+	for len(lines) > 0 && lines[len(lines)-1].Offset >= last.Position {
+		lines = lines[:len(lines)-1]
+	}
+	c.scopes[c.scopeIndex].lines = lines
 }
 
 func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
@@ -340,6 +893,8 @@ func (c *Compiler) changeOperand(opPos, operand int) {
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	Lines        code.LineTable
+	Handlers     code.HandlerTable
 }
 
 func (c *Compiler) enterScope() {
@@ -375,12 +930,174 @@ func (c *Compiler) loadSymbol(s Symbol) {
 	case GlobalScope:
 		c.emit(code.OpGetGlobal, s.Index)
 	case LocalScope:
-		c.emit(code.OpGetLocal, s.Index)
+		if s.Cell {
+			c.emit(code.OpGetLocalCell, s.Index)
+		} else {
+			c.emit(code.OpGetLocal, s.Index)
+		}
 	case BuiltinScope:
 		c.emit(code.OpGetBuiltin, s.Index)
 	case FreeScope:
-		c.emit(code.OpGetFree, s.Index)
+		if s.Cell {
+			c.emit(code.OpGetFreeCell, s.Index)
+		} else {
+			c.emit(code.OpGetFree, s.Index)
+		}
 	case FunctionScope:
 		c.emit(code.OpCurrentClosure)
 	}
 }
+
+// loadCapture pushes the value a new closure captures for s. For variables
+// living in a cell that is the cell itself rather than its content.
+func (c *Compiler) loadCapture(s Symbol) {
+	switch {
+	case s.Cell && s.Scope == LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case s.Cell && s.Scope == FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	default:
+		c.loadSymbol(s)
+	}
+}
+
+// storeDefinition pops the value on top of the stack into s, which has just
+// been defined.
+func (c *Compiler) storeDefinition(s Symbol) {
+	if s.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, s.Index)
+		return
+	}
+	c.emit(code.OpSetLocal, s.Index)
+	if s.Cell {
+		c.emit(code.OpMakeCell, s.Index)
+	}
+}
+
+func (c *Compiler) storeSymbol(s Symbol) error {
+	switch {
+	case s.Scope == GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case s.Scope == LocalScope && s.Cell:
+		c.emit(code.OpSetLocalCell, s.Index)
+	case s.Scope == LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+	case s.Scope == FreeScope && s.Cell:
+		c.emit(code.OpSetFreeCell, s.Index)
+	case s.Scope == BuiltinScope:
+		return fmt.Errorf("cannot assign to builtin %s", s.Name)
+	default:
+		return fmt.Errorf("cannot assign to %s", s.Name)
+	}
+	return nil
+}
+
+var compoundOperators = map[string]code.Opcode{
+	"+=": code.OpAdd,
+	"-=": code.OpSub,
+	"*=": code.OpMul,
+	"/=": code.OpDiv,
+}
+
+func (c *Compiler) compileAssignExpression(node *ast.AssignExpression) error {
+	op, compound := compoundOperators[node.Operator]
+	if !compound && node.Operator != "=" {
+		return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
+	}
+
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(target.Value)
+		if !ok {
+			return fmt.Errorf("%s: undefined variable %s", target.Pos(), target.Value)
+		}
+
+		pushed := 0
+		if compound {
+			c.loadSymbol(symbol)
+			pushed = 1
+		}
+		if err := c.compileAbove(pushed, node.Value); err != nil {
+			return err
+		}
+		if compound {
+			c.emit(op)
+		}
+
+		if err := c.storeSymbol(symbol); err != nil {
+			return fmt.Errorf("%s: %s", target.Pos(), err)
+		}
+		c.loadSymbol(symbol)
+	case *ast.IndexExpression:
+		if err := c.Compile(target.Left); err != nil {
+			return err
+		}
+		if err := c.compileAbove(1, target.Index); err != nil {
+			return err
+		}
+		pushed := 2
+		if compound {
+			c.emit(code.OpDup2)
+			c.emit(code.OpIndex)
+			pushed = 3
+		}
+		if err := c.compileAbove(pushed, node.Value); err != nil {
+			return err
+		}
+		if compound {
+			c.emit(op)
+		}
+		c.emit(code.OpSetIndex)
+	default:
+		return fmt.Errorf("%s: cannot assign to %s", node.Pos(), node.Target)
+	}
+
+	return nil
+}
+
+// cellVariables returns the names of fn's locals that have to be stored in
+// cells: those that are assigned to and referenced from a nested function.
+// Names are matched without regard to shadowing, which at worst puts a
+// variable in a cell that did not need one.
+func cellVariables(fn *ast.FunctionLiteral) map[string]bool {
+	assigned := assignedNames(fn.Body)
+	cells := make(map[string]bool)
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		inner, ok := n.(*ast.FunctionLiteral)
+		if !ok {
+			return true
+		}
+		ast.Inspect(inner.Body, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Identifier); ok && assigned[id.Value] {
+				cells[id.Value] = true
+			}
+			return true
+		})
+		return false
+	})
+
+	return cells
+}
+
+// assignedNames returns the names assigned to anywhere in node, including
+// in the functions it contains.
+func assignedNames(node ast.Node) map[string]bool {
+	assigned := make(map[string]bool)
+	ast.Inspect(node, func(n ast.Node) bool {
+		if assign, ok := n.(*ast.AssignExpression); ok {
+			if id, ok := assign.Target.(*ast.Identifier); ok {
+				assigned[id.Value] = true
+			}
+		}
+		return true
+	})
+	return assigned
+}
+
+// assignsOwnName reports whether node is a named function assigning to its
+// name. Its name then refers to the variable the function was bound to,
+// as in the evaluator, rather than to the closure being run.
+func assignsOwnName(node ast.Node) bool {
+	fn, ok := node.(*ast.FunctionLiteral)
+	return ok && fn.Name != "" && assignedNames(fn.Body)[fn.Name]
+}
@@ -1,12 +1,15 @@
 package compiler
 
 import (
+	"bytes"
 	"fmt"
 	"monkey/ast"
 	"monkey/code"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -103,6 +106,26 @@ func TestBooleanExpression(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             "1 >= 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterThanOrEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 <= 2",
+			expectedConstants: []interface{}{2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterThanOrEqual),
+				code.Make(code.OpPop),
+			},
+		},
 	}
 
 	runCompilerTest(t, tests)
@@ -169,6 +192,87 @@ func TestTestIntegerArithmetic(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             "5 % 2",
+			expectedConstants: []interface{}{5, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMod),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "2 ** 3",
+			expectedConstants: []interface{}{2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPow),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 & 2 | 3 ^ 4",
+			expectedConstants: []interface{}{1, 2, 3, 4},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpBitAnd),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpBitXor),
+				code.Make(code.OpBitOr),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 << 2 >> 3",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpShiftLeft),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpShiftRight),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "~1",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpBitNot),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTest(t, tests)
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1.5 + 2",
+			expectedConstants: []interface{}{1.5, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "-0.5",
+			expectedConstants: []interface{}{0.5},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMinus),
+				code.Make(code.OpPop),
+			},
+		},
 	}
 
 	runCompilerTest(t, tests)
@@ -342,14 +446,14 @@ func TestIndexExpressions(t *testing.T) {
 	runCompilerTest(t, []compilerTestCase{
 		{
 			input:             "[1, 2, 3][1 + 1]",
-			expectedConstants: []interface{}{1, 2, 3, 1, 1},
+			expectedConstants: []interface{}{1, 2, 3},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpConstant, 2),
 				code.Make(code.OpArray, 3),
-				code.Make(code.OpConstant, 3),
-				code.Make(code.OpConstant, 4),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpAdd),
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
@@ -357,13 +461,13 @@ func TestIndexExpressions(t *testing.T) {
 		},
 		{
 			input:             "{1: 2}[2 - 1]",
-			expectedConstants: []interface{}{1, 2, 2, 1},
+			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpHash, 2),
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpSub),
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
@@ -697,7 +801,7 @@ func TestBuiltins(t *testing.T) {
 				[]code.Instructions{
 					code.Make(code.OpGetBuiltin, 0),
 					code.Make(code.OpArray, 0),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 			},
@@ -852,16 +956,15 @@ func TestRecursiveFunctions(t *testing.T) {
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpSub),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
-				1,
 			},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpClosure, 1, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpCall, 1),
 				code.Make(code.OpPop),
 			},
@@ -881,21 +984,20 @@ func TestRecursiveFunctions(t *testing.T) {
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpSub),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
-				1,
 				[]code.Instructions{
 					code.Make(code.OpClosure, 1, 0),
 					code.Make(code.OpSetLocal, 0),
 					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpConstant, 2),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpClosure, 2, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpCall, 0),
@@ -907,11 +1009,11 @@ func TestRecursiveFunctions(t *testing.T) {
 	runCompilerTest(t, tests)
 }
 
-func runCompilerTest(t *testing.T, tests []compilerTestCase) {
+func runCompilerTest(t *testing.T, tests []compilerTestCase, opts ...Option) {
 	t.Helper()
 
 	for _, tt := range tests {
-		compiler := New()
+		compiler := New(opts...)
 		program := parse(tt.input)
 		if err := compiler.Compile(program); err != nil {
 			t.Fatalf("compiler error: %s", err)
@@ -968,6 +1070,12 @@ func testConstants(
 				return fmt.Errorf("constant %d - testIntegerObject failed: %s",
 					i, err)
 			}
+		case float64:
+			err := testFloatObject(constant, actual[i])
+			if err != nil {
+				return fmt.Errorf("constant %d - testFloatObject failed: %s",
+					i, err)
+			}
 		case []code.Instructions:
 			fn, ok := actual[i].(*object.CompiledFunction)
 			if !ok {
@@ -1015,6 +1123,21 @@ func testIntegerObject(expected int64, actual object.Object) error {
 	return nil
 }
 
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T (%+v)",
+			actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%f, want=%f",
+			result.Value, expected)
+	}
+
+	return nil
+}
+
 func concatInstructions(s []code.Instructions) code.Instructions {
 	out := code.Instructions{}
 
@@ -1029,3 +1152,1140 @@ func parse(input string) *ast.Program {
 	p := parser.New(l)
 	return p.ParseProgram()
 }
+
+func TestLineTable(t *testing.T) {
+	input := `let one = 1;
+let f = fn() {
+	one;
+};
+f();`
+
+	compiler := New()
+	if err := compiler.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	expected := code.LineTable{
+		{Offset: 0, Line: 1},
+		{Offset: 6, Line: 2},
+		{Offset: 13, Line: 5},
+	}
+	if err := testLineTable(expected, bytecode.Lines); err != nil {
+		t.Fatalf("main line table wrong: %s", err)
+	}
+
+	fn, ok := bytecode.Constants[1].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant 1 not a function: %T", bytecode.Constants[1])
+	}
+	if fn.Name != "f" {
+		t.Errorf("function has wrong name. want=%q, got=%q", "f", fn.Name)
+	}
+	if err := testLineTable(code.LineTable{{Offset: 0, Line: 3}}, fn.Lines); err != nil {
+		t.Fatalf("function line table wrong: %s", err)
+	}
+}
+
+func testLineTable(expected, actual code.LineTable) error {
+	if len(expected) != len(actual) {
+		return fmt.Errorf("wrong number of entries. want=%v, got=%v", expected, actual)
+	}
+	for i, entry := range expected {
+		if actual[i] != entry {
+			return fmt.Errorf("wrong entry at %d. want=%v, got=%v", i, expected, actual)
+		}
+	}
+	return nil
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let a = 1; a = 2;",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let a = 1; a += 2;",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let a = [1]; a[0] *= 2;",
+			expectedConstants: []interface{}{1, 0, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDup2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpMul),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn(a) { a = 1; }`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			fn(a) {
+				let b = 0;
+				fn() { b = a; }
+			}`,
+			expectedConstants: []interface{}{
+				0,
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 1),
+					code.Make(code.OpSetFreeCell, 0),
+					code.Make(code.OpGetFreeCell, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpMakeCell, 1),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 1, 2),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			fn(a) {
+				fn() { a += 1; }
+			}`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetFreeCell, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpSetFreeCell, 0),
+					code.Make(code.OpGetFreeCell, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpMakeCell, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 1, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTest(t, tests)
+}
+
+func TestAssignExpressionErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a = 1;", "1:1: undefined variable a"},
+		{"len = 1;", "1:1: cannot assign to builtin len"},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		err := compiler.Compile(parse(tt.input))
+		if err == nil {
+			t.Fatalf("expected compiler error but resulted in none.")
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong compiler error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "while (true) { 1; break; }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 14),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpPop),
+				// 0008
+				code.Make(code.OpJump, 14),
+				// 0011
+				code.Make(code.OpJump, 0),
+			},
+		},
+		{
+			input:             "for (x in [1]) { continue; }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpArray, 1),
+				// 0006
+				code.Make(code.OpGetIter),
+				// 0007
+				code.Make(code.OpIterNext, 19),
+				// 0010
+				code.Make(code.OpSetGlobal, 0),
+				// 0013
+				code.Make(code.OpJump, 7),
+				// 0016
+				code.Make(code.OpJump, 7),
+			},
+		},
+		{
+			input:             "for (x in [1]) { break; }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpArray, 1),
+				// 0006
+				code.Make(code.OpGetIter),
+				// 0007
+				code.Make(code.OpIterNext, 20),
+				// 0010
+				code.Make(code.OpSetGlobal, 0),
+				// 0013
+				code.Make(code.OpPop),
+				// 0014
+				code.Make(code.OpJump, 20),
+				// 0017
+				code.Make(code.OpJump, 7),
+			},
+		},
+		{
+			input:             "if (true) { let x = 1; }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 14),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpSetGlobal, 0),
+				// 0010
+				code.Make(code.OpNull),
+				// 0011
+				code.Make(code.OpJump, 15),
+				// 0014
+				code.Make(code.OpNull),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTest(t, tests)
+}
+
+func TestLoopControlErrors(t *testing.T) {
+	tests := []struct {
+		program  *ast.Program
+		expected string
+	}{
+		{
+			&ast.Program{Statements: []ast.Statement{&ast.BreakStatement{}}},
+			"break outside of loop",
+		},
+		{
+			&ast.Program{Statements: []ast.Statement{&ast.ContinueStatement{}}},
+			"continue outside of loop",
+		},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		err := compiler.Compile(tt.program)
+		if err == nil {
+			t.Fatalf("expected compiler error but resulted in none.")
+		}
+		if !strings.HasSuffix(err.Error(), tt.expected) {
+			t.Errorf("wrong compiler error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestLogicalExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "true && false; 1",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthyOrPop, 5),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpPop),
+				// 0006
+				code.Make(code.OpConstant, 0),
+				// 0009
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "false || true",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpFalse),
+				// 0001
+				code.Make(code.OpJumpTruthyOrPop, 5),
+				// 0004
+				code.Make(code.OpTrue),
+				// 0005
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTest(t, tests)
+}
+
+func TestInterpolatedStrings(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `"a${1}b"`,
+			expectedConstants: []interface{}{"a", 1, "b"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConcat, 3),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"${true}"`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpConcat, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTest(t, tests)
+}
+
+func TestConstantFolding(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2 * 3",
+			expectedConstants: []interface{}{7},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "-5; ~0",
+			expectedConstants: []interface{}{-5, -1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "!true; !!5; 1 < 2 == true; 3 >= 4 || false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"mon" + "key"`,
+			expectedConstants: []interface{}{"monkey"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let x = 1; x + 2 * 3",
+			expectedConstants: []interface{}{1, 6},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// operations that fail at run time are left alone
+			input:             "1 / 0; 1 << -1",
+			expectedConstants: []interface{}{1, 0, -1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDiv),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpShiftLeft),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTest(t, tests, WithOptimizations(true))
+}
+
+func TestConditionalPruning(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "if (true) { 10 } else { 20 }; 3333;",
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (1 > 2) { 10 } else { 20 }",
+			expectedConstants: []interface{}{20},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (!true) { 10 }",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1; if (true) { }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let x = true; if (x) { 10 }",
+			expectedConstants: []interface{}{10},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpJumpNotTruthy, 16),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpJump, 17),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (false) { let a = 1; a }; let b = 2; b",
+			expectedConstants: []interface{}{2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTest(t, tests, WithOptimizations(true))
+}
+
+func TestConditionalPruningErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"if (false) { undefinedVar }", "1:14: undefined variable undefinedVar"},
+		{"if (true) { 1 } else { len = 1 }", "1:24: cannot assign to builtin len"},
+	}
+
+	for _, tt := range tests {
+		compiler := New(WithOptimizations(true))
+		err := compiler.Compile(parse(tt.input))
+		if err == nil {
+			t.Fatalf("expected compiler error but resulted in none.")
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong compiler error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestConstantInterning(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `1; "a"; 1; "a"; 1.5; 1.5; "1"`,
+			expectedConstants: []interface{}{1, "a", 1.5, "1"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(x) { x }; fn(x) { x }; fn(y) { y + 1 }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTest(t, tests)
+}
+
+func TestConstantInterningWithState(t *testing.T) {
+	first := New()
+	if err := first.Compile(parse(`1; "two"`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	second := NewWithState(NewSymbolTable(), first.Bytecode().Constants)
+	if err := second.Compile(parse(`"two"; 3; 1`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := second.Bytecode()
+
+	expected := []code.Instructions{
+		code.Make(code.OpConstant, 1),
+		code.Make(code.OpPop),
+		code.Make(code.OpConstant, 2),
+		code.Make(code.OpPop),
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpPop),
+	}
+	if err := testInstruction(expected, bytecode.Instructions); err != nil {
+		t.Fatalf("testInstructions fail: %s", err)
+	}
+	if err := testConstants(t, []interface{}{1, "two", 3}, bytecode.Constants); err != nil {
+		t.Fatalf("testConstants fail: %s", err)
+	}
+}
+
+func TestConstantPoolOverflow(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i <= MaxConstants; i++ {
+		fmt.Fprintf(&input, "%d;\n", i)
+	}
+
+	compiler := New()
+	err := compiler.Compile(parse(input.String()))
+	if err == nil {
+		t.Fatalf("expected compiler error")
+	}
+	expected := fmt.Sprintf("%d:1: too many constants: the pool is limited to %d",
+		MaxConstants+1, MaxConstants)
+	if err.Error() != expected {
+		t.Errorf("wrong error. want=%q, got=%q", expected, err.Error())
+	}
+}
+
+func TestPeephole(t *testing.T) {
+	tests := []struct {
+		name          string
+		before        []code.Instructions
+		beforeLines   code.LineTable
+		expected      []code.Instructions
+		expectedLines code.LineTable
+	}{
+		{
+			name: "jump to next instruction",
+			before: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJump, 4),
+				code.Make(code.OpPop),
+			},
+			expected: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			name: "jump chains",
+			before: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 7),
+				code.Make(code.OpJump, 11),
+				code.Make(code.OpJump, 11),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+			expected: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 4),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			name: "jump cycle",
+			before: []code.Instructions{
+				code.Make(code.OpJump, 3),
+				code.Make(code.OpJump, 0),
+			},
+			expected: []code.Instructions{
+				code.Make(code.OpJump, 0),
+			},
+		},
+		{
+			name: "negated condition",
+			before: []code.Instructions{
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpBang),
+				code.Make(code.OpJumpNotTruthy, 9),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+			expected: []code.Instructions{
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpJumpTruthy, 8),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			name: "negated condition that is a jump target",
+			before: []code.Instructions{
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpJumpTruthyOrPop, 8),
+				code.Make(code.OpTrue),
+				code.Make(code.OpBang),
+				code.Make(code.OpJumpNotTruthy, 12),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+			expected: []code.Instructions{
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpJumpTruthyOrPop, 8),
+				code.Make(code.OpTrue),
+				code.Make(code.OpBang),
+				code.Make(code.OpJumpNotTruthy, 12),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			name: "unreachable code after return",
+			before: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 7),
+				code.Make(code.OpNull),
+				code.Make(code.OpReturnValue),
+				code.Make(code.OpPop),
+				code.Make(code.OpNull),
+				code.Make(code.OpReturnValue),
+			},
+			beforeLines: code.LineTable{
+				{Offset: 0, Line: 1},
+				{Offset: 6, Line: 2},
+				{Offset: 7, Line: 3},
+			},
+			expected: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 6),
+				code.Make(code.OpNull),
+				code.Make(code.OpReturnValue),
+				code.Make(code.OpNull),
+				code.Make(code.OpReturnValue),
+			},
+			expectedLines: code.LineTable{
+				{Offset: 0, Line: 1},
+				{Offset: 6, Line: 3},
+			},
+		},
+		{
+			name: "local and constant arithmetic",
+			before: []code.Instructions{
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpGetLocal, 1),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSub),
+				code.Make(code.OpGetLocal, 1),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMul),
+				code.Make(code.OpReturnValue),
+			},
+			expected: []code.Instructions{
+				code.Make(code.OpGetLocalConstAdd, 0, 1),
+				code.Make(code.OpGetLocalConstSub, 1, 0),
+				code.Make(code.OpGetLocal, 1),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMul),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			name: "comparison and jump",
+			before: []code.Instructions{
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpGreaterThan),
+				code.Make(code.OpJumpNotTruthy, 11),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+			expected: []code.Instructions{
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpCompareJump, 11, int(code.OpGreaterThan)),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			name: "call with one argument",
+			before: []code.Instructions{
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+			},
+			expected: []code.Instructions{
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpCall1),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := concatInstructions(tt.before)
+			ins, lines, _ := optimizeInstructions(before, tt.beforeLines, nil)
+			if err := testInstruction(tt.expected, ins); err != nil {
+				t.Fatalf("testInstructions fail: %s", err)
+			}
+			if err := testLineTable(tt.expectedLines, lines); err != nil {
+				t.Fatalf("testLineTable fail: %s", err)
+			}
+			if !bytes.Equal(before, concatInstructions(tt.before)) {
+				t.Errorf("input instructions were modified")
+			}
+		})
+	}
+}
+
+func TestPeepholeFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn(x) { if (!x) { return 1 }; 2 }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpJumpTruthy, 9),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+					code.Make(code.OpNull),
+					code.Make(code.OpPop),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let x = 1; while (!x) { break; }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpJumpTruthy, 12),
+			},
+		},
+	}
+
+	runCompilerTest(t, tests, WithOptimizations(true))
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn(g) { g(1) + 1; g(2) }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(g) { if (g) { return g(); } else { g() } }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpJumpNotTruthy, 14),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpTailCall, 0),
+					code.Make(code.OpReturnValue),
+					code.Make(code.OpNull),
+					code.Make(code.OpJump, 18),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpTailCall, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(g) { if (g) { g() } else { 1 } }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpJumpNotTruthy, 12),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpTailCall, 0),
+					code.Make(code.OpJump, 15),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(g) { try { return g(); } catch { 0 } }",
+			expectedConstants: []interface{}{
+				0,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpReturnValue),
+					code.Make(code.OpJump, 16),
+					code.Make(code.OpPop),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpPop),
+					code.Make(code.OpJump, 16),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "let g = fn() { 1 }; g()",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTest(t, tests)
+}
+
+func TestTryStatements(t *testing.T) {
+	tests := []struct {
+		compilerTestCase
+		// handlers are those of main, or of the last constant when it is a
+		// function
+		handlers code.HandlerTable
+	}{
+		{
+			compilerTestCase: compilerTestCase{
+				input:             "try { 1; } catch (e) { 2; } finally { 3; }",
+				expectedConstants: []interface{}{1, 3, 2},
+				expectedInstructions: []code.Instructions{
+					// 0000
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpPop),
+					// 0004
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpPop),
+					code.Make(code.OpJump, 30),
+					// 0011
+					code.Make(code.OpSetGlobal, 0),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpPop),
+					// 0018
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpPop),
+					code.Make(code.OpJump, 30),
+					// 0025
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpPop),
+					code.Make(code.OpThrow),
+				},
+			},
+			handlers: code.HandlerTable{
+				{Start: 0, End: 4, Target: 11},
+				{Start: 0, End: 4, Target: 25},
+				{Start: 11, End: 18, Target: 25},
+			},
+		},
+		{
+			compilerTestCase: compilerTestCase{
+				input:             "[1, if (true) { try { 2; } catch { } 3 }]",
+				expectedConstants: []interface{}{1, 2, 3},
+				expectedInstructions: []code.Instructions{
+					// 0000
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpTrue),
+					code.Make(code.OpJumpNotTruthy, 24),
+					// 0007
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpPop),
+					code.Make(code.OpJump, 18),
+					// 0014
+					code.Make(code.OpPop),
+					code.Make(code.OpJump, 18),
+					// 0018
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpJump, 25),
+					// 0024
+					code.Make(code.OpNull),
+					// 0025
+					code.Make(code.OpArray, 2),
+					code.Make(code.OpPop),
+				},
+			},
+			handlers: code.HandlerTable{
+				{Start: 7, End: 11, Target: 14, StackDepth: 1},
+			},
+		},
+		{
+			compilerTestCase: compilerTestCase{
+				input: "fn() { try { return 1; } finally { 2; } }",
+				expectedConstants: []interface{}{
+					1,
+					2,
+					[]code.Instructions{
+						// 0000
+						code.Make(code.OpConstant, 0),
+						code.Make(code.OpConstant, 1),
+						code.Make(code.OpPop),
+						code.Make(code.OpReturnValue),
+						// 0008
+						code.Make(code.OpConstant, 1),
+						code.Make(code.OpPop),
+						code.Make(code.OpJump, 20),
+						// 0015
+						code.Make(code.OpConstant, 1),
+						code.Make(code.OpPop),
+						code.Make(code.OpThrow),
+						// 0020
+						code.Make(code.OpReturn),
+					},
+				},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpClosure, 2, 0),
+					code.Make(code.OpPop),
+				},
+			},
+			handlers: code.HandlerTable{
+				{Start: 0, End: 3, Target: 15},
+				{Start: 7, End: 8, Target: 15},
+			},
+		},
+		{
+			compilerTestCase: compilerTestCase{
+				input:             "throw 1;",
+				expectedConstants: []interface{}{1},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpThrow),
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		runCompilerTest(t, []compilerTestCase{tt.compilerTestCase})
+
+		compiler := New()
+		if err := compiler.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := compiler.Bytecode()
+		handlers := bytecode.Handlers
+		if fn, ok := bytecode.Constants[len(bytecode.Constants)-1].(*object.CompiledFunction); ok {
+			handlers = fn.Handlers
+		}
+		if !reflect.DeepEqual(handlers, tt.handlers) {
+			t.Errorf("%q: wrong handlers.\nwant=%+v\ngot =%+v", tt.input, tt.handlers, handlers)
+		}
+	}
+}
+
+func TestTryStatementsOptimized(t *testing.T) {
+	compiler := New(WithOptimizations(true))
+	if err := compiler.Compile(parse("try { 1; } catch { } 2;")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	expected := []code.Instructions{
+		// 0000
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpPop),
+		code.Make(code.OpJump, 8),
+		// 0007
+		code.Make(code.OpPop),
+		// 0008
+		code.Make(code.OpConstant, 1),
+		code.Make(code.OpPop),
+	}
+	if err := testInstruction(expected, bytecode.Instructions); err != nil {
+		t.Fatalf("testInstructions fail: %s", err)
+	}
+	want := code.HandlerTable{{Start: 0, End: 4, Target: 7}}
+	if !reflect.DeepEqual(bytecode.Handlers, want) {
+		t.Errorf("wrong handlers.\nwant=%+v\ngot =%+v", want, bytecode.Handlers)
+	}
+}
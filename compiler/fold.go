@@ -0,0 +1,165 @@
+package compiler
+
+import (
+	"monkey/ast"
+	"monkey/code"
+	"monkey/object"
+)
+
+// fold computes the value of an expression made only of integer, boolean
+// and string literals and the operators applied to them. It reports false
+// for anything else, including operations that fail at run time such as a
+// division by zero, so that they still report their error when executed.
+func fold(node ast.Expression) (object.Object, bool) {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return &object.Integer{Value: node.Value}, true
+	case *ast.Boolean:
+		return &object.Boolean{Value: node.Value}, true
+	case *ast.StringLiteral:
+		return &object.String{Value: node.Value}, true
+	case *ast.PrefixExpression:
+		right, ok := fold(node.Right)
+		if !ok {
+			return nil, false
+		}
+		return foldPrefix(node.Operator, right)
+	case *ast.InfixExpression:
+		left, ok := fold(node.Left)
+		if !ok {
+			return nil, false
+		}
+		right, ok := fold(node.Right)
+		if !ok {
+			return nil, false
+		}
+		return foldInfix(node.Operator, left, right)
+	}
+	return nil, false
+}
+
+func foldPrefix(operator string, right object.Object) (object.Object, bool) {
+	if operator == "!" {
+		return &object.Boolean{Value: !constantTruthy(right)}, true
+	}
+
+	integer, ok := right.(*object.Integer)
+	if !ok {
+		return nil, false
+	}
+	switch operator {
+	case "-":
+		return &object.Integer{Value: -integer.Value}, true
+	case "~":
+		return &object.Integer{Value: ^integer.Value}, true
+	}
+	return nil, false
+}
+
+func foldInfix(operator string, left, right object.Object) (object.Object, bool) {
+	switch operator {
+	case "&&":
+		if !constantTruthy(left) {
+			return left, true
+		}
+		return right, true
+	case "||":
+		if constantTruthy(left) {
+			return left, true
+		}
+		return right, true
+	}
+
+	switch left := left.(type) {
+	case *object.Integer:
+		if right, ok := right.(*object.Integer); ok {
+			return foldIntegerInfix(operator, left.Value, right.Value)
+		}
+	case *object.Boolean:
+		if right, ok := right.(*object.Boolean); ok {
+			switch operator {
+			case "==":
+				return &object.Boolean{Value: left.Value == right.Value}, true
+			case "!=":
+				return &object.Boolean{Value: left.Value != right.Value}, true
+			}
+		}
+	case *object.String:
+		if right, ok := right.(*object.String); ok && operator == "+" {
+			return &object.String{Value: left.Value + right.Value}, true
+		}
+	}
+	return nil, false
+}
+
+func foldIntegerInfix(operator string, left, right int64) (object.Object, bool) {
+	var result int64
+	switch operator {
+	case "+":
+		result = left + right
+	case "-":
+		result = left - right
+	case "*":
+		result = left * right
+	case "/", "%":
+		if right == 0 {
+			return nil, false
+		}
+		if operator == "/" {
+			result = left / right
+		} else {
+			result = left % right
+		}
+	case "&":
+		result = left & right
+	case "|":
+		result = left | right
+	case "^":
+		result = left ^ right
+	case "<<", ">>":
+		if right < 0 {
+			return nil, false
+		}
+		if operator == "<<" {
+			result = left << right
+		} else {
+			result = left >> right
+		}
+	case "<":
+		return &object.Boolean{Value: left < right}, true
+	case "<=":
+		return &object.Boolean{Value: left <= right}, true
+	case ">":
+		return &object.Boolean{Value: left > right}, true
+	case ">=":
+		return &object.Boolean{Value: left >= right}, true
+	case "==":
+		return &object.Boolean{Value: left == right}, true
+	case "!=":
+		return &object.Boolean{Value: left != right}, true
+	default:
+		return nil, false
+	}
+	return &object.Integer{Value: result}, true
+}
+
+// constantTruthy mirrors the VM's truthiness for the values fold produces.
+func constantTruthy(obj object.Object) bool {
+	if b, ok := obj.(*object.Boolean); ok {
+		return b.Value
+	}
+	return true
+}
+
+// emitFolded emits the instruction pushing the value fold computed for node.
+func (c *Compiler) emitFolded(node ast.Node, obj object.Object) error {
+	if b, ok := obj.(*object.Boolean); ok {
+		if b.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+		return nil
+	}
+	return c.emitConstant(node, obj)
+}
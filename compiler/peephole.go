@@ -0,0 +1,229 @@
+package compiler
+
+import "monkey/code"
+
+// peepholeInstruction is a decoded instruction as seen by the peephole pass.
+type peepholeInstruction struct {
+	offset   int
+	op       code.Opcode
+	operands []int
+	removed  bool
+}
+
+// optimizeInstructions runs the peephole pass over the instructions of one
+// function until none of its rewrites applies any more:
+//
+//   - jumps to an OpJump are redirected to the final target of the chain
+//   - an OpJump to the instruction right after it is removed
+//   - OpBang followed by OpJumpNotTruthy becomes OpJumpTruthy
+//   - code after OpReturnValue, OpReturn, OpJump or OpThrow that no jump or
+//     handler reaches is removed
+//   - sequences with a superinstruction are fused into it, see code.OpCall1
+//
+// The bounds and targets of handlers count as jump targets, so no rewrite
+// crosses them. Jump operands, lines and handlers are rewritten to the new
+// offsets. ins, lines and handlers are left unchanged.
+func optimizeInstructions(ins code.Instructions, lines code.LineTable, handlers code.HandlerTable) (code.Instructions, code.LineTable, code.HandlerTable) {
+	for {
+		decoded := decodeInstructions(ins)
+		if !peephole(decoded, len(ins), handlers) {
+			return ins, lines, handlers
+		}
+		ins, lines, handlers = encodeInstructions(decoded, len(ins), lines, handlers)
+	}
+}
+
+func decodeInstructions(ins code.Instructions) []*peepholeInstruction {
+	var decoded []*peepholeInstruction
+	for i := 0; i < len(ins); {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			// the compiler never emits undefined opcodes
+			panic(err)
+		}
+		operands, read := code.ReadOperands(def, ins[i+1:])
+		decoded = append(decoded, &peepholeInstruction{
+			offset:   i,
+			op:       code.Opcode(ins[i]),
+			operands: operands,
+		})
+		i += 1 + read
+	}
+	return decoded
+}
+
+// peephole applies one round of rewrites to decoded, the instructions of a
+// function length bytes long, and reports whether it changed anything.
+func peephole(decoded []*peepholeInstruction, length int, handlers code.HandlerTable) bool {
+	byOffset := make(map[int]*peepholeInstruction, len(decoded))
+	for _, in := range decoded {
+		byOffset[in.offset] = in
+	}
+	targets := map[int]bool{}
+	for _, in := range decoded {
+		if code.IsJump(in.op) {
+			targets[in.operands[0]] = true
+		}
+	}
+	for _, h := range handlers {
+		targets[h.Start] = true
+		targets[h.End] = true
+		targets[h.Target] = true
+	}
+
+	changed := false
+	for i, in := range decoded {
+		if in.removed {
+			continue
+		}
+
+		if code.IsJump(in.op) {
+			if target := threadJump(in.operands[0], byOffset); target != in.operands[0] {
+				in.operands[0] = target
+				targets[target] = true
+				changed = true
+			}
+		}
+
+		switch in.op {
+		case code.OpJump:
+			if next := nextInstruction(decoded, i, length); next == in.operands[0] {
+				in.removed = true
+				changed = true
+			}
+		case code.OpGetLocal:
+			if i+2 < len(decoded) {
+				constant, arithmetic := decoded[i+1], decoded[i+2]
+				fused, ok := localConstArithmetic[arithmetic.op]
+				if ok && constant.op == code.OpConstant &&
+					!targets[constant.offset] && !targets[arithmetic.offset] {
+					in.op = fused
+					in.operands = []int{in.operands[0], constant.operands[0]}
+					constant.removed = true
+					arithmetic.removed = true
+					changed = true
+				}
+			}
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterThanOrEqual:
+			if i+1 < len(decoded) {
+				jump := decoded[i+1]
+				if jump.op == code.OpJumpNotTruthy && !targets[jump.offset] {
+					in.operands = []int{jump.operands[0], int(in.op)}
+					in.op = code.OpCompareJump
+					jump.removed = true
+					changed = true
+				}
+			}
+		case code.OpCall:
+			if in.operands[0] == 1 {
+				in.op = code.OpCall1
+				in.operands = nil
+				changed = true
+			}
+		case code.OpBang:
+			if i+1 < len(decoded) {
+				jump := decoded[i+1]
+				if jump.op == code.OpJumpNotTruthy && !targets[jump.offset] {
+					in.removed = true
+					jump.op = code.OpJumpTruthy
+					changed = true
+				}
+			}
+		}
+
+		if !in.removed && (in.op == code.OpReturnValue || in.op == code.OpReturn ||
+			in.op == code.OpJump || in.op == code.OpThrow) {
+			for _, dead := range decoded[i+1:] {
+				if targets[dead.offset] {
+					break
+				}
+				dead.removed = true
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// localConstArithmetic maps the arithmetic opcodes that can follow
+// OpGetLocal and OpConstant to the superinstruction fusing the three.
+var localConstArithmetic = map[code.Opcode]code.Opcode{
+	code.OpAdd: code.OpGetLocalConstAdd,
+	code.OpSub: code.OpGetLocalConstSub,
+}
+
+// threadJump follows a chain of OpJumps starting at target and returns the
+// offset it ends at. Cycles stop at the first instruction seen twice.
+func threadJump(target int, byOffset map[int]*peepholeInstruction) int {
+	seen := map[int]bool{}
+	for !seen[target] {
+		seen[target] = true
+		in, ok := byOffset[target]
+		if !ok || in.removed || in.op != code.OpJump {
+			break
+		}
+		target = in.operands[0]
+	}
+	return target
+}
+
+// nextInstruction returns the offset of the first instruction after
+// decoded[i] that has not been removed, or length if there is none.
+func nextInstruction(decoded []*peepholeInstruction, i, length int) int {
+	for _, in := range decoded[i+1:] {
+		if !in.removed {
+			return in.offset
+		}
+	}
+	return length
+}
+
+// encodeInstructions re-encodes the instructions that have not been removed
+// and rewrites jump operands, line entries and handlers to the new offsets.
+// A jump to a removed instruction lands on the next one that was kept.
+func encodeInstructions(decoded []*peepholeInstruction, length int, lines code.LineTable, handlers code.HandlerTable) (code.Instructions, code.LineTable, code.HandlerTable) {
+	newOffsets := make(map[int]int, len(decoded)+1)
+	offset := 0
+	for _, in := range decoded {
+		newOffsets[in.offset] = offset
+		if !in.removed {
+			offset += len(code.Make(in.op, in.operands...))
+		}
+	}
+	newOffsets[length] = offset
+
+	ins := code.Instructions{}
+	for _, in := range decoded {
+		if in.removed {
+			continue
+		}
+		if code.IsJump(in.op) {
+			in.operands[0] = newOffsets[in.operands[0]]
+		}
+		ins = append(ins, code.Make(in.op, in.operands...)...)
+	}
+
+	var newLines code.LineTable
+	for _, entry := range lines {
+		entry.Offset = newOffsets[entry.Offset]
+		if entry.Offset >= len(ins) {
+			continue
+		}
+		if n := len(newLines); n > 0 && newLines[n-1].Offset == entry.Offset {
+			newLines = newLines[:n-1]
+		}
+		if n := len(newLines); n > 0 && newLines[n-1].Line == entry.Line {
+			continue
+		}
+		newLines = append(newLines, entry)
+	}
+
+	var newHandlers code.HandlerTable
+	for _, h := range handlers {
+		h.Start, h.End, h.Target = newOffsets[h.Start], newOffsets[h.End], newOffsets[h.Target]
+		if h.Start < h.End {
+			newHandlers = append(newHandlers, h)
+		}
+	}
+	return ins, newLines, newHandlers
+}
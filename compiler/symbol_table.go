@@ -14,6 +14,9 @@ type Symbol struct {
 	Name  string
 	Scope SymbolScope
 	Index int
+	// Cell is set for locals, and free variables referring to them, that
+	// are stored in an *object.Cell.
+	Cell bool
 }
 
 type SymbolTable struct {
@@ -22,6 +25,9 @@ type SymbolTable struct {
 	store          map[string]Symbol
 	numDefinitions int
 	FreeSymbols    []Symbol
+
+	// cells names the locals of this scope that need a cell
+	cells map[string]bool
 }
 
 func NewSymbolTable() *SymbolTable {
@@ -48,6 +54,7 @@ func (s *SymbolTable) Define(name string) Symbol {
 		symbol.Scope = GlobalScope
 	} else {
 		symbol.Scope = LocalScope
+		symbol.Cell = s.cells[name]
 	}
 	s.store[name] = symbol
 	s.numDefinitions++
@@ -83,6 +90,7 @@ func (s *SymbolTable) defineFree(original Symbol) Symbol {
 
 	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1}
 	symbol.Scope = FreeScope
+	symbol.Cell = original.Cell
 
 	s.store[original.Name] = symbol
 	return symbol
@@ -0,0 +1,50 @@
+package compiler
+
+import "monkey/code"
+
+// markTailCalls turns every OpCall in ins whose result is returned right
+// away, possibly after a chain of OpJumps, into an OpTailCall. Calls covered
+// by one of handlers are left alone: the frame running the handler has to
+// stay around for the exceptions the call raises.
+func markTailCalls(ins code.Instructions, handlers code.HandlerTable) {
+	decoded := decodeInstructions(ins)
+	byOffset := make(map[int]*peepholeInstruction, len(decoded))
+	for _, in := range decoded {
+		byOffset[in.offset] = in
+	}
+
+	for i, in := range decoded {
+		if in.op != code.OpCall || handlers.Covers(in.offset) {
+			continue
+		}
+		next := len(ins)
+		if i+1 < len(decoded) {
+			next = decoded[i+1].offset
+		}
+		if returnsAt(next, byOffset) {
+			ins[in.offset] = byte(code.OpTailCall)
+		}
+	}
+}
+
+// returnsAt reports whether execution continuing at offset reaches an
+// OpReturnValue without doing anything but jump.
+func returnsAt(offset int, byOffset map[int]*peepholeInstruction) bool {
+	seen := map[int]bool{}
+	for !seen[offset] {
+		seen[offset] = true
+		in, ok := byOffset[offset]
+		if !ok {
+			return false
+		}
+		switch in.op {
+		case code.OpReturnValue:
+			return true
+		case code.OpJump:
+			offset = in.operands[0]
+		default:
+			return false
+		}
+	}
+	return false
+}
@@ -1,15 +1,20 @@
 package evaluator
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"monkey/ast"
 	"monkey/object"
+	"strings"
 )
 
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL     = object.NULL
+	TRUE     = object.TRUE
+	FALSE    = object.FALSE
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
 func isError(obj object.Object) bool {
@@ -19,6 +24,17 @@ func isError(obj object.Object) bool {
 	return false
 }
 
+// EvalContext evaluates node like Eval, but stops with an error once ctx is
+// done. The context is checked before every loop iteration and function
+// call. The Err of that error matches object.ErrTimeout with errors.Is.
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment) object.Object {
+	previous := env.Context()
+	env.SetContext(ctx)
+	defer env.SetContext(previous)
+
+	return Eval(node, env)
+}
+
 func Eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	case *ast.Program:
@@ -27,33 +43,36 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return Eval(node.Expression, env)
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isAbrupt(right) {
 			return right
 		}
 		return evalPrefixExpression(node.Operator, right)
 	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, env)
+		}
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isAbrupt(left) {
 			return left
 		}
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isAbrupt(right) {
 			return right
 		}
 		return evalInfixExpression(node.Operator, left, right)
 	case *ast.BlockStatement:
-		return evalBlockStatement(node, env)
+		return evalBlockStatement(node, env, false)
 	case *ast.IfExpression:
-		return evalIfExpression(node, env)
+		return evalIfExpression(node, env, false)
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, env)
-		if isError(val) {
+		val := evalTail(node.ReturnValue, env)
+		if isAbrupt(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
-		if isError(val) {
+		if isAbrupt(val) {
 			return val
 		}
 		env.Set(node.Name.Value, val)
@@ -68,51 +87,327 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			Env:        env,
 		}
 	case *ast.CallExpression:
-		function := Eval(node.Function, env)
-		if isError(function) {
-			return function
-		}
-		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
-			return args[0]
+		result := evalTailCall(node, env)
+		if call, ok := result.(*object.TailCall); ok {
+			return applyFunction(call.Function, call.Arguments)
 		}
-		return applyFunction(function, args)
+		return result
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
+	case *ast.InterpolatedString:
+		return evalInterpolatedString(node, env)
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
-		if len(elements) == 1 && isError(elements[0]) {
+		if len(elements) == 1 && isAbrupt(elements[0]) {
 			return elements[0]
 		}
 		return &object.Array{Elements: elements}
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isAbrupt(left) {
 			return left
 		}
 		index := Eval(node.Index, env)
-		if isError(index) {
+		if isAbrupt(index) {
 			return index
 		}
 
 		return evalIndexExpression(left, index)
 	case *ast.HashLiteral:
 		return evalHashLiteral(node, env)
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env)
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+	case *ast.BreakStatement:
+		return BREAK
+	case *ast.ContinueStatement:
+		return CONTINUE
+	case *ast.TryStatement:
+		return evalTryStatement(node, env)
+	case *ast.ThrowStatement:
+		val := Eval(node.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+		return newThrownError(val)
+	}
+
+	return nil
+}
+
+// evalLogicalExpression evaluates && and ||, short-circuiting on the left
+// operand. The result is the operand that decided it, not a boolean.
+func evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isAbrupt(left) {
+		return left
+	}
+
+	if isTruthy(left) == (node.Operator == "||") {
+		return left
+	}
+	return Eval(node.Right, env)
+}
+
+// evalInterpolatedString joins the parts of node into one string, using
+// Inspect for values that are not strings.
+func evalInterpolatedString(node *ast.InterpolatedString, env *object.Environment) object.Object {
+	var out strings.Builder
+	for _, part := range node.Parts {
+		val := Eval(part, env)
+		if isAbrupt(val) {
+			return val
+		}
+		out.WriteString(val.Inspect())
+	}
+	return &object.String{Value: out.String()}
+}
+
+func evalWhileStatement(node *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		condition := Eval(node.Condition, env)
+		if isAbrupt(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			return nil
+		}
+
+		if result, done := evalLoopBody(node.Body, env); done {
+			return result
+		}
+	}
+}
+
+func evalForStatement(node *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := Eval(node.Iterable, env)
+	if isAbrupt(iterable) {
+		return iterable
+	}
+	iter, ok := object.NewIterator(iterable)
+	if !ok {
+		return newError("not iterable: %s", iterable.Type())
+	}
+
+	for {
+		item, ok := iter.Next()
+		if !ok {
+			return nil
+		}
+		env.Set(node.Variable.Value, item)
+
+		if result, done := evalLoopBody(node.Body, env); done {
+			return result
+		}
+	}
+}
+
+// evalLoopBody runs one iteration of a loop. It reports whether the loop is
+// done, along with the result the loop statement evaluates to in that case.
+func evalLoopBody(body *ast.BlockStatement, env *object.Environment) (object.Object, bool) {
+	if err := checkContext(env); err != nil {
+		return err, true
+	}
+
+	result := Eval(body, env)
+	if result == nil {
+		return nil, false
+	}
+
+	switch result.Type() {
+	case object.BREAK_OBJ:
+		return nil, true
+	case object.RETURN_VALUE_OBJ, object.ERROR_OBJ:
+		return result, true
+	}
+	return nil, false
+}
+
+// evalTryStatement runs the body of node, its catch clause when the body
+// fails with an error, and its finally clause in any case. A return, break,
+// continue or error in the finally clause takes the place of how the body or
+// catch clause ended. Once the context is done errors are not caught.
+func evalTryStatement(node *ast.TryStatement, env *object.Environment) object.Object {
+	result := resolveTailCall(evalBlockStatement(node.Body, env, false))
+
+	if errObj, ok := result.(*object.Error); ok && node.Catch != nil && checkContext(env) == nil {
+		if node.Param != nil {
+			env.Set(node.Param.Value, thrownValue(errObj))
+		}
+		result = evalBlockStatement(node.Catch, env, false)
+		if node.Finally != nil {
+			result = resolveTailCall(result)
+		}
 	}
 
+	if node.Finally != nil {
+		if finally := evalBlockStatement(node.Finally, env, false); isAbrupt(finally) {
+			return finally
+		}
+	}
+	if isAbrupt(result) {
+		return result
+	}
 	return nil
 }
 
+// resolveTailCall makes the call of a return statement in tail position, so
+// that it runs inside the try statement returning it.
+func resolveTailCall(result object.Object) object.Object {
+	returnValue, ok := result.(*object.ReturnValue)
+	if !ok {
+		return result
+	}
+	call, ok := returnValue.Value.(*object.TailCall)
+	if !ok {
+		return result
+	}
+	value := applyFunction(call.Function, call.Arguments)
+	if isError(value) {
+		return value
+	}
+	return &object.ReturnValue{Value: value}
+}
+
+// isAbrupt reports whether result ends the enclosing block early. Such a
+// result also stops the expression it comes from and becomes its value, so
+// that a break inside an array literal, say, leaves the loop right away.
+func isAbrupt(result object.Object) bool {
+	if result == nil {
+		return false
+	}
+	switch result.Type() {
+	case object.RETURN_VALUE_OBJ, object.ERROR_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+		return true
+	}
+	return false
+}
+
+// newThrownError returns the error raised by throwing val.
+func newThrownError(val object.Object) *object.Error {
+	return &object.Error{Message: "uncaught exception: " + val.Inspect(), Value: val}
+}
+
+// thrownValue returns the value a catch clause receives for errObj: the
+// value thrown, or the message of an error raised by the interpreter.
+func thrownValue(errObj *object.Error) object.Object {
+	if errObj.Value != nil {
+		return errObj.Value
+	}
+	return &object.String{Value: errObj.Message}
+}
+
+func evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		// The current value of a compound assignment is read before the
+		// right-hand side runs, as the compiled code does, so that
+		// `c += f()` ignores whatever f assigns to c. The same goes for
+		// `a[i] += f()` below.
+		var current object.Object
+		if node.Operator != "=" {
+			var ok bool
+			current, ok = env.Get(target.Value)
+			if !ok {
+				return newError("identifier not found: " + target.Value)
+			}
+		}
+
+		val := Eval(node.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+
+		if current != nil {
+			val = evalCompoundAssignment(node.Operator, current, val)
+			if isError(val) {
+				return val
+			}
+		}
+
+		if !env.Assign(target.Value, val) {
+			return newError("identifier not found: " + target.Value)
+		}
+		return val
+	case *ast.IndexExpression:
+		left := Eval(target.Left, env)
+		if isAbrupt(left) {
+			return left
+		}
+		index := Eval(target.Index, env)
+		if isAbrupt(index) {
+			return index
+		}
+		var current object.Object
+		if node.Operator != "=" {
+			current = evalIndexExpression(left, index)
+			if isError(current) {
+				return current
+			}
+		}
+		val := Eval(node.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+
+		if current != nil {
+			val = evalCompoundAssignment(node.Operator, current, val)
+			if isError(val) {
+				return val
+			}
+		}
+
+		return evalIndexAssignment(left, index, val)
+	default:
+		return newError("cannot assign to %s", node.Target)
+	}
+}
+
+// evalCompoundAssignment applies the arithmetic part of an operator such as
+// "+=" to the current and the assigned value.
+func evalCompoundAssignment(operator string, current, val object.Object) object.Object {
+	return evalInfixExpression(strings.TrimSuffix(operator, "="), current, val)
+}
+
+func evalIndexAssignment(left, index, val object.Object) object.Object {
+	switch left := left.(type) {
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return newError("index operator not supported: %s[%s]", left.Type(), index.Type())
+		}
+		if i.Value < 0 || i.Value >= int64(len(left.Elements)) {
+			return newError("index out of range: %d", i.Value)
+		}
+		left.Elements[i.Value] = val
+		return val
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		left.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: val}
+		return val
+	default:
+		return newError("index assignment not supported: %s", left.Type())
+	}
+}
+
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
 	pairs := make(map[object.HashKey]object.HashPair)
 
 	for keyNode, valueNode := range node.Pairs {
 		key := Eval(keyNode, env)
-		if isError(key) {
+		if isAbrupt(key) {
 			return key
 		}
 
@@ -122,7 +417,7 @@ func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Obje
 		}
 
 		value := Eval(valueNode, env)
-		if isError(value) {
+		if isAbrupt(value) {
 			return value
 		}
 
@@ -134,25 +429,25 @@ func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Obje
 }
 
 func evalIndexExpression(left, index object.Object) object.Object {
-	switch {
-	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+	switch left := left.(type) {
+	case *object.Array:
 		return evalArrayIndexExpression(left, index)
-	case left.Type() == object.HASH_OBJ:
+	case *object.String:
+		return evalStringIndexExpression(left, index)
+	case *object.Hash:
 		return evalHashIndexExpression(left, index)
 	default:
 		return newError("index operator not supported: %s", left.Type())
 	}
 }
 
-func evalHashIndexExpression(hash, index object.Object) object.Object {
-	hashObject := hash.(*object.Hash)
-
+func evalHashIndexExpression(hash *object.Hash, index object.Object) object.Object {
 	key, ok := index.(object.Hashable)
 	if !ok {
 		return newError("unusable as hash key: %s", index.Type())
 	}
 
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	pair, ok := hash.Pairs[key.HashKey()]
 	if !ok {
 		return NULL
 	}
@@ -160,29 +455,99 @@ func evalHashIndexExpression(hash, index object.Object) object.Object {
 	return pair.Value
 }
 
-func evalArrayIndexExpression(array object.Object, index object.Object) object.Object {
-	arrayObject := array.(*object.Array)
-	idx := index.(*object.Integer).Value
-	upperBound := int64(len(arrayObject.Elements) - 1)
+// evalStringIndexExpression returns the character at a rune index as a
+// string.
+func evalStringIndexExpression(str *object.String, index object.Object) object.Object {
+	integer, ok := index.(*object.Integer)
+	if !ok {
+		return newError("index operator not supported: %s[%s]", str.Type(), index.Type())
+	}
+	runes := []rune(str.Value)
+	idx := integer.Value
+	if idx < 0 || idx >= int64(len(runes)) {
+		return NULL
+	}
+	return &object.String{Value: string(runes[idx])}
+}
+
+func evalArrayIndexExpression(array *object.Array, index object.Object) object.Object {
+	integer, ok := index.(*object.Integer)
+	if !ok {
+		return newError("index operator not supported: %s[%s]", array.Type(), index.Type())
+	}
+	idx := integer.Value
+	upperBound := int64(len(array.Elements) - 1)
 	if idx < 0 || idx > upperBound {
 		return NULL
 	}
-	return arrayObject.Elements[idx]
+	return array.Elements[idx]
 }
 
+// applyFunction calls fn. Calls in tail position of a function body come
+// back as a TailCall and are made here in a loop, so that tail recursion
+// runs in constant Go stack.
 func applyFunction(fn object.Object, args []object.Object) object.Object {
-	switch fn := fn.(type) {
-	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
-		return unwrapReturnValue(evaluated)
-	case *object.Builtin:
-		if result := fn.Fn(args...); result != nil {
-			return result
+	for {
+		switch function := fn.(type) {
+		case *object.Function:
+			if err := checkContext(function.Env); err != nil {
+				return err
+			}
+			if len(args) != len(function.Parameters) {
+				return newError("wrong number of arguments: want=%d, got=%d",
+					len(function.Parameters), len(args))
+			}
+			extendedEnv := extendFunctionEnv(function, args)
+			evaluated := unwrapReturnValue(evalBlockStatement(function.Body, extendedEnv, true))
+			if evaluated == nil {
+				// a body without a value, such as one ending in a loop
+				return NULL
+			}
+			call, ok := evaluated.(*object.TailCall)
+			if !ok {
+				return evaluated
+			}
+			fn, args = call.Function, call.Arguments
+		case *object.Builtin:
+			if result := function.Fn(args...); result != nil {
+				return result
+			}
+			return NULL
+		default:
+			return newError("not a function: %s", fn.Type())
 		}
-		return NULL
+	}
+}
+
+// evalTailCall evaluates the function and arguments of a call and returns
+// them as a TailCall without making the call.
+func evalTailCall(node *ast.CallExpression, env *object.Environment) object.Object {
+	function := Eval(node.Function, env)
+	if isAbrupt(function) {
+		return function
+	}
+	args := evalExpressions(node.Arguments, env)
+	if len(args) == 1 && isAbrupt(args[0]) {
+		return args[0]
+	}
+	return &object.TailCall{Function: function, Arguments: args}
+}
+
+// evalTail evaluates node in tail position, where its value becomes the
+// result of the function being applied. Calls found there are returned as
+// a TailCall for applyFunction to make.
+func evalTail(node ast.Node, env *object.Environment) object.Object {
+	switch node := node.(type) {
+	case *ast.ExpressionStatement:
+		return evalTail(node.Expression, env)
+	case *ast.CallExpression:
+		return evalTailCall(node, env)
+	case *ast.IfExpression:
+		return evalIfExpression(node, env, true)
+	case *ast.BlockStatement:
+		return evalBlockStatement(node, env, true)
 	default:
-		return newError("not a function: %s", fn.Type())
+		return Eval(node, env)
 	}
 }
 
@@ -206,7 +571,7 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 
 	for _, exp := range exps {
 		evaluated := Eval(exp, env)
-		if isError(evaluated) {
+		if isAbrupt(evaluated) {
 			return []object.Object{evaluated}
 		}
 		result = append(result, evaluated)
@@ -215,6 +580,20 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 	return result
 }
 
+// checkContext returns an error once the context env is evaluated in is
+// done, see EvalContext. Its Err wraps object.ErrTimeout and the error of the
+// context.
+func checkContext(env *object.Environment) *object.Error {
+	ctx := env.Context()
+	select {
+	case <-ctx.Done():
+		err := fmt.Errorf("%w: %w", object.ErrTimeout, ctx.Err())
+		return &object.Error{Message: err.Error(), Err: err}
+	default:
+		return nil
+	}
+}
+
 func newError(format string, a ...any) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
@@ -229,18 +608,24 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 	return newError("identifier not found: " + node.Value)
 }
 
-func evalIfExpression(node *ast.IfExpression, env *object.Environment) object.Object {
+func evalIfExpression(node *ast.IfExpression, env *object.Environment, tail bool) object.Object {
 	condition := Eval(node.Condition, env)
-	if isError(condition) {
+	if isAbrupt(condition) {
 		return condition
 	}
+	var result object.Object
 	if isTruthy(condition) {
-		return Eval(node.Consequence, env)
+		result = evalBlockStatement(node.Consequence, env, tail)
 	} else if node.Alternative != nil {
-		return Eval(node.Alternative, env)
-	} else {
+		result = evalBlockStatement(node.Alternative, env, tail)
+	}
+
+	// a branch without a value, such as one ending in a let statement,
+	// evaluates to null
+	if result == nil {
 		return NULL
 	}
+	return result
 }
 
 func isTruthy(condition object.Object) bool {
@@ -262,6 +647,8 @@ func evalInfixExpression(operator string, left object.Object, right object.Objec
 		return evalStringInfixExpression(operator, left, right)
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
+	case object.IsNumber(left) && object.IsNumber(right):
+		return evalFloatInfixExpression(operator, left, right)
 	case operator == "==":
 		return nativeBoolToBooleanObject(left == right)
 	case operator == "!=":
@@ -293,12 +680,76 @@ func evalIntegerInfixExpression(operator string, left object.Object, right objec
 		return &object.Integer{Value: leftVal - rightVal}
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
+	case "/", "%":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		if operator == "/" {
+			return &object.Integer{Value: leftVal / rightVal}
+		}
+		return &object.Integer{Value: leftVal % rightVal}
+	case "**":
+		if rightVal < 0 {
+			return newError("negative exponent: %d", rightVal)
+		}
+		return &object.Integer{Value: object.IntPow(leftVal, rightVal)}
+	case "&":
+		return &object.Integer{Value: leftVal & rightVal}
+	case "|":
+		return &object.Integer{Value: leftVal | rightVal}
+	case "^":
+		return &object.Integer{Value: leftVal ^ rightVal}
+	case "<<", ">>":
+		if rightVal < 0 {
+			return newError("negative shift count: %d", rightVal)
+		}
+		if operator == "<<" {
+			return &object.Integer{Value: leftVal << rightVal}
+		}
+		return &object.Integer{Value: leftVal >> rightVal}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+// evalFloatInfixExpression evaluates arithmetic and comparisons between two
+// numbers of which at least one is a float.
+func evalFloatInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+	leftVal := object.ToFloat(left)
+	rightVal := object.ToFloat(right)
+	switch operator {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
 	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
+	case "**":
+		return &object.Float{Value: math.Pow(leftVal, rightVal)}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
@@ -314,17 +765,26 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 		return evalBangOperatorExpression(right)
 	case "-":
 		return evalMinusPrefixOperatorExpression(right)
+	case "~":
+		integer, ok := right.(*object.Integer)
+		if !ok {
+			return newError("unknown operator: ~%s", right.Type())
+		}
+		return &object.Integer{Value: ^integer.Value}
 	default:
 		return newError("unknown operator: %s%s", operator, right.Type())
 	}
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
+	switch right := right.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: -right.Value}
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
 }
 
 func evalBangOperatorExpression(right object.Object) object.Object {
@@ -348,6 +808,9 @@ func evalProgram(stmts []ast.Statement, env *object.Environment) object.Object {
 
 		switch result := result.(type) {
 		case *object.ReturnValue:
+			if call, ok := result.Value.(*object.TailCall); ok {
+				return applyFunction(call.Function, call.Arguments)
+			}
 			return result.Value
 		case *object.Error:
 			return result
@@ -357,13 +820,19 @@ func evalProgram(stmts []ast.Statement, env *object.Environment) object.Object {
 	return result
 }
 
-func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
+// evalBlockStatement evaluates block. When tail is set the block is in tail
+// position and so is its last statement, see evalTail.
+func evalBlockStatement(block *ast.BlockStatement, env *object.Environment, tail bool) object.Object {
 	var result object.Object
-	for _, stmt := range block.Statements {
+	for i, stmt := range block.Statements {
+		if tail && i == len(block.Statements)-1 {
+			return evalTail(stmt, env)
+		}
 		result = Eval(stmt, env)
 		if result != nil {
 			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ ||
+				rt == object.BREAK_OBJ || rt == object.CONTINUE_OBJ {
 				return result
 			}
 		}
@@ -1,6 +1,8 @@
 package evaluator
 
 import (
+	"context"
+	"errors"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
@@ -27,6 +29,18 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"3 * 3 * 3 + 10", 37},
 		{"3 * (3 * 3) + 10", 37},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"3 ** 0", 1},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"1 << 4", 16},
+		{"256 >> 2", 64},
+		{"~5", -6},
+		{"1 + 2 & 3", 3},
 	}
 
 	for _, tt := range tests {
@@ -35,6 +49,59 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 }
 
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"2.5", 2.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2.0},
+		{"5 / 2.0", 2.5},
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"1.0 == 1", true},
+		{"1.5 != 1.5", false},
+		{"int(2.9)", 2},
+		{`int("42")`, 42},
+		{"float(3)", 3.0},
+		{`float("0.25")`, 0.25},
+		{"7.5 % 2", 1.5},
+		{"2 ** 0.5", 1.4142135623730951},
+		{"2.0 ** 2", 4.0},
+		{"1.5 <= 1.5", true},
+		{"1 >= 1.5", false},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		}
+	}
+}
+
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	t.Helper()
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object is not Float. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("wrong value. got=%f, want=%f", result.Value, expected)
+		return false
+	}
+	return true
+}
+
 func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	t.Helper()
 	result, ok := obj.(*object.Integer)
@@ -71,6 +138,12 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"1 != 1", false},
 		{"1 == 2", false},
 		{"1 != 2", true},
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{"3 >= 2", true},
 		{"true == true", true},
 		{"false == false", true},
 		{"true == false", false},
@@ -201,6 +274,22 @@ func TestErrorHandling(t *testing.T) {
 			"-true",
 			"unknown operator: -BOOLEAN",
 		},
+		{
+			"~1.5",
+			"unknown operator: ~FLOAT",
+		},
+		{
+			"2 ** -1",
+			"negative exponent: -1",
+		},
+		{
+			"1 << -1",
+			"negative shift count: -1",
+		},
+		{
+			"1.5 & 1",
+			"unknown operator: FLOAT & INTEGER",
+		},
 		{
 			"true + false;",
 			"unknown operator: BOOLEAN + BOOLEAN",
@@ -229,6 +318,26 @@ if (10 > 1) {
 			"foobar",
 			"identifier not found: foobar",
 		},
+		{
+			"1 / 0",
+			"division by zero",
+		},
+		{
+			"let x = 0; 5 % x",
+			"division by zero",
+		},
+		{
+			`[1, 2]["a"]`,
+			"index operator not supported: ARRAY[STRING]",
+		},
+		{
+			`"abc"[true]`,
+			"index operator not supported: STRING[BOOLEAN]",
+		},
+		{
+			"fn(a) { a }()",
+			"wrong number of arguments: want=1, got=0",
+		},
 	}
 
 	for i, tt := range tests {
@@ -291,6 +400,18 @@ func TestFunctionObject(t *testing.T) {
 	}
 }
 
+func TestFunctionsWithoutValue(t *testing.T) {
+	tests := []string{
+		"let f = fn() { let a = 1; }; f()",
+		"let f = fn() { while (false) { } }; [f()][0]",
+		"let f = fn() { for (x in []) { } }; len([f()]) - 1; f()",
+	}
+
+	for _, input := range tests {
+		testNullObject(t, testEval(input))
+	}
+}
+
 func TestFunctionApplication(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -345,6 +466,7 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
+		{`len("naïve 世界")`, 8},
 		{`len(1)`, "argument to `len` not supported, got INTEGER"},
 		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
 	}
@@ -532,3 +654,350 @@ func TestHashIndexExpressions(t *testing.T) {
 		}
 	}
 }
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let a = 1; a = 2; a;", 2},
+		{"let a = 1; a = 2;", 2},
+		{"let a = 1; let b = 2; a = b = 3; a + b;", 6},
+		{"let a = 10; a += 5; a -= 3; a *= 2; a /= 4; a;", 6},
+		{"let a = [1, 2, 3]; a[1] = 5; a[1];", 5},
+		{"let a = [1, 2, 3]; a[2] += 10; a[2];", 13},
+		{"let c = 0; let k = fn() { c = 10; 1 }; c += k(); c;", 1},
+		{"let a = [0]; let k = fn() { a[0] = 10; 1 }; a[0] += k(); a[0];", 1},
+		{`let h = {"n": 1}; let k = fn() { h["n"] = 10; 2 }; h["n"] *= k(); h["n"];`, 2},
+		{`let h = {"k": 1}; h["k"] = 2; h["n"] = 3; h["k"] + h["n"];`, 5},
+		{"let a = 1; let f = fn() { a = a + 1; }; f(); f(); a;", 3},
+		{"let f = fn() { f = 1 }; f(); f;", 1},
+		{"let f = fn(n) { if (n == 0) { f = 99; 0 } else { f(n - 1) } }; f(3); f;", 99},
+		{"fn() { let f = fn() { f = 5 }; f(); f }()", 5},
+		{"fn() { let f = fn(n) { if (n > 0) { f(n - 1) } else { f = 7 } }; f(2); f }()", 7},
+		{
+			`let counter = fn() {
+				let count = 0;
+				fn() { count += 1; count };
+			};
+			let c = counter();
+			c(); c(); c();`,
+			3,
+		},
+		{"b = 1;", "identifier not found: b"},
+		{"let a = [1]; a[5] = 1;", "index out of range: 5"},
+		{`let a = 1; a += "x";`, "type mismatch: INTEGER + STRING"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q",
+					expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let i = 0; while (i < 5) { i += 1; } i;", 5},
+		{"let i = 0; while (true) { i += 1; if (i > 2) { break; } } i;", 3},
+		{
+			`let i = 0; let sum = 0;
+			while (i < 5) { i += 1; if (i == 2) { continue; } sum += i; }
+			sum;`,
+			13,
+		},
+		{"let sum = 0; for (x in [1, 2, 3]) { sum += x; } sum;", 6},
+		{`let n = 0; for (k in {"a": 1, "b": 2}) { n += 1; } n;`, 2},
+		{`let n = 0; for (c in "héllo") { n += 1; } n;`, 5},
+		{
+			`let n = 0;
+			for (x in [1, 2, 3]) {
+				for (y in [1, 2, 3]) { if (y > x) { break; } n += 1; }
+			}
+			n;`,
+			6,
+		},
+		{"fn() { for (x in [1, 2, 3]) { if (x == 2) { return x * 10; } } 0 }()", 20},
+		{
+			`let n = 0;
+			for (x in [1, 2, 3, 4]) {
+				n = n + [x, if (x == 2) { continue } else { x }][1];
+				[1, 2, if (x == 3) { break } else { 0 }]
+			}
+			n;`,
+			4,
+		},
+		{"let n = 0; for (x in [1, 2, 3]) { let y = [x, if (x == 2) { break } else { 0 }]; n += y[0]; } n;", 1},
+		{`let n = 0; while (n < 3) { n += 1; puts("${if (true) { continue }}"); n = 10; } n;`, 3},
+		{"fn() { let a = [1, if (true) { return 7; }]; 0 }()", 7},
+		{"for (x in 1) {}", "not iterable: INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q",
+					expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestTryStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let r = 0; try { throw 5 } catch (e) { r = e } r", 5},
+		{"let r = 0; try { r = 1 } catch (e) { r = 2 } r", 1},
+		{"let r = \"\"; try { len(1) } catch (e) { r = e } r", "argument to `len` not supported, got INTEGER"},
+		{"let n = 0; try { n = 1; throw 0 } catch { n = n * 10 + 2 } finally { n = n * 10 + 3 } n", 123},
+		{"let n = 0; let f = fn() { try { return 1 } finally { n = 5 } }; f() + n", 6},
+		{"fn() { try { return 1 } finally { return 2 } }()", 2},
+		{"fn() { try { throw 1 } finally { return 2 } }()", 2},
+		{"fn() { try { throw 1 } catch (e) { return e + 1 } finally { } }()", 2},
+		{
+			`let f = fn() { try { throw "a" } catch (e) { throw e + "b" } };
+			let r = ""; try { f() } catch (e) { r = e } r`,
+			"ab",
+		},
+		{
+			`let g = fn(n) { if (n == 0) { throw 7 } g(n - 1) + 1 };
+			let r = 0; try { g(5) } catch (e) { r = e } r`,
+			7,
+		},
+		{"let r = 0; try { try { throw 1 } finally { r += 10 } } catch (e) { r += e } r", 11},
+		{
+			`let n = 0;
+			for (x in [1, 2, 3, 4]) {
+				try { if (x == 2) { continue } if (x == 4) { break } n += x } finally { n += 10 }
+			}
+			n`,
+			44,
+		},
+		{
+			`let f = fn() {
+				for (x in [1, 2]) { try { return x } finally { } }
+			};
+			f()`,
+			1,
+		},
+		{"[1, if (true) { try { throw 1 } catch { }; 2 }, 3][1]", 2},
+		{"let add = fn(a, b) { a + b }; add(1, if (true) { try { len(1) } catch { }; 2 })", 3},
+		{"let f = fn() { try { throw 4 } catch (e) { return fn() { e * 2 } } }; f()()", 8},
+		{"let f = fn(n) { try { if (n > 0) { return f(n - 1) } throw n } catch (e) { return e + 1 } }; f(3)", 1},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("%q: object is not String. got=%T (%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("%q: String has wrong value. got=%q", tt.input, str.Value)
+			}
+		}
+	}
+}
+
+func TestUncaughtExceptions(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"throw 1", "uncaught exception: 1"},
+		{`throw "boom"`, "uncaught exception: boom"},
+		{"try { throw 1 } catch (e) { throw e + 1 }", "uncaught exception: 2"},
+		{"try { throw 1 } finally { }", "uncaught exception: 1"},
+		{"let f = fn() { throw [1, 2] }; f()", "uncaught exception: [1, 2]"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%q: no error object returned. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("%q: wrong error message. expected=%q, got=%q",
+				tt.input, tt.expectedMessage, errObj.Message)
+		}
+	}
+}
+
+func TestLogicalExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"true && true", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && 2", 2},
+		{"0 || 2", 0},
+		{"false || 2", 2},
+		{"1 < 2 && 2 < 3", true},
+		{"let n = 0; false && (n = 1); n", 0},
+		{"let n = 0; true || (n = 1); n", 0},
+		{"let n = 0; true && (n = 1); n", 1},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestStringIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`"héllo"[1]`, "é"},
+		{`"héllo"[4]`, "o"},
+		{`"héllo"[5]`, nil},
+		{`"héllo"[-1]`, nil},
+		{`"a\tb"[1]`, "\t"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		str, ok := tt.expected.(string)
+		if !ok {
+			testNullObject(t, evaluated)
+			continue
+		}
+		result, ok := evaluated.(*object.String)
+		if !ok {
+			t.Errorf("object is not String. got=%T (%+v)", evaluated, evaluated)
+			continue
+		}
+		if result.Value != str {
+			t.Errorf("String has wrong value. got=%q, want=%q", result.Value, str)
+		}
+	}
+}
+
+func TestInterpolatedStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let name = "bob"; "hello ${name}!"`, "hello bob!"},
+		{`let items = [1, 2]; "${len(items)} items: ${items}"`, "2 items: [1, 2]"},
+		{`"${1.5 * 2} ${true} ${"x"}"`, "3.0 true x"},
+		{`"${ "in${1 + 1}" }"`, "in2"},
+		{`"\${x}"`, "${x}"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		str, ok := evaluated.(*object.String)
+		if !ok {
+			t.Errorf("object is not String. got=%T (%+v)", evaluated, evaluated)
+			continue
+		}
+		if str.Value != tt.expected {
+			t.Errorf("String has wrong value. got=%q, want=%q", str.Value, tt.expected)
+		}
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let countdown = fn(n) { if (n == 0) { 0 } else { countdown(n - 1) } }; countdown(1000000)", 0},
+		{"let sum = fn(n, acc) { if (n == 0) { return acc; } return sum(n - 1, acc + n); }; sum(100000, 0)", 5000050000},
+		{`
+		let even = fn(n) { if (n == 0) { 1 } else { odd(n - 1) } };
+		let odd = fn(n) { if (n == 0) { 0 } else { even(n - 1) } };
+		even(100001)
+		`, 0},
+		{"let f = fn(n) { while (true) { return g(n); } }; let g = fn(n) { n * 2 }; f(21)", 42},
+		{"let f = fn(a) { len(a) }; f([1, 2, 3])", 3},
+		{"let f = fn(n) { n + 1 }; return f(1);", 2},
+		{"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(100)", 100},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestEvalContext(t *testing.T) {
+	inputs := []string{
+		"while (true) { }",
+		"let f = fn() { f() }; f()",
+		"let f = fn() { 1 + f() }; f()",
+		"try { while (true) { } } catch (e) { }",
+	}
+
+	for _, input := range inputs {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		program := parser.New(lexer.New(input)).ParseProgram()
+		evaluated := EvalContext(ctx, program, object.NewEnvironment())
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%q: no error object returned. got=%T (%+v)", input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != "execution timed out: context canceled" {
+			t.Errorf("%q: wrong error message. got=%q", input, errObj.Message)
+		}
+		if !errors.Is(errObj.Err, object.ErrTimeout) || !errors.Is(errObj.Err, context.Canceled) {
+			t.Errorf("%q: error does not match ErrTimeout and context.Canceled. got=%v", input, errObj.Err)
+		}
+	}
+
+	env := object.NewEnvironment()
+	program := parser.New(lexer.New("let x = 5; while (x > 0) { x -= 1 }; x")).ParseProgram()
+	testIntegerObject(t, EvalContext(context.Background(), program, env), 0)
+}
@@ -1,34 +1,84 @@
 package lexer
 
-import "monkey/token"
+import (
+	"fmt"
+	"monkey/token"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
 
 type Lexer struct {
+	file         string
 	input        string
 	position     int
 	readPosition int
-	ch           byte
+	ch           rune
+
+	// line and column of ch
+	line   int
+	column int
+
+	keepComments bool
+
+	// interpolations holds, for each "${" being lexed, innermost last, the
+	// number of '{' opened within it and not yet closed. A '}' closing the
+	// "${" itself resumes the string.
+	interpolations []int
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewWithFile("", input)
+}
+
+func NewWithFile(file, input string) *Lexer {
+	l := &Lexer{file: file, input: input, line: 1}
 	l.readChar()
 	return l
 }
 
+// KeepComments makes the lexer return comments as COMMENT tokens instead of
+// skipping them, for tools that need to reproduce the source. The parser
+// does not accept COMMENT tokens.
+func (l *Lexer) KeepComments() {
+	l.keepComments = true
+}
+
 func (l *Lexer) readChar() {
+	if l.readPosition > len(l.input) {
+		// already at EOF, keep reporting the end of input
+		return
+	}
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	width := 1
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
-		l.ch = l.input[l.readPosition]
+		l.ch, width = utf8.DecodeRuneInString(l.input[l.readPosition:])
 	}
 	l.position = l.readPosition
-	l.readPosition++
+	l.readPosition += width
+	l.column++
+}
+
+func (l *Lexer) currentPosition() token.Position {
+	return token.Position{
+		File:   l.file,
+		Line:   l.line,
+		Column: l.column,
+		Offset: l.position,
+	}
 }
 
 func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
 
 	l.skipWhitespace()
+	start := l.currentPosition()
 
 	switch l.ch {
 	case '!':
@@ -44,15 +94,40 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.BANG, l.ch)
 		}
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		tok = l.newAssignToken(token.MINUS, token.MINUS_ASSIGN)
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		if l.peekChar() == '/' || l.peekChar() == '*' {
+			// only reached for kept or unterminated comments, others are
+			// skipped along with whitespace
+			literal, ok := l.readComment()
+			tok = token.Token{Type: token.COMMENT, Literal: literal}
+			if !ok {
+				tok = token.Token{Type: token.ERROR, Literal: "unterminated comment"}
+			}
+			tok.Pos, tok.End = start, l.currentPosition()
+			return tok
+		}
+		tok = l.newAssignToken(token.SLASH, token.SLASH_ASSIGN)
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		if l.peekChar() == '*' {
+			tok = l.newDoubleToken(token.ASTERISK, token.POWER)
+		} else {
+			tok = l.newAssignToken(token.ASTERISK, token.ASTERISK_ASSIGN)
+		}
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		if l.peekChar() == '<' {
+			tok = l.newDoubleToken(token.LT, token.SHL)
+		} else {
+			tok = l.newAssignToken(token.LT, token.LT_EQ)
+		}
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		if l.peekChar() == '>' {
+			tok = l.newDoubleToken(token.GT, token.SHR)
+		} else {
+			tok = l.newAssignToken(token.GT, token.GT_EQ)
+		}
 	case '=':
 		if l.peekChar() == '=' {
 			ch := l.ch
@@ -74,20 +149,39 @@ func (l *Lexer) NextToken() token.Token {
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		tok = l.newAssignToken(token.PLUS, token.PLUS_ASSIGN)
 	case '{':
+		if n := len(l.interpolations); n > 0 {
+			l.interpolations[n-1]++
+		}
 		tok = newToken(token.LBRACE, l.ch)
 	case '}':
+		n := len(l.interpolations)
+		if n > 0 && l.interpolations[n-1] == 0 {
+			l.interpolations = l.interpolations[:n-1]
+			tok = l.readStringToken(token.TEMPLATE_MIDDLE, token.TEMPLATE_TAIL)
+			break
+		}
+		if n > 0 {
+			l.interpolations[n-1]--
+		}
 		tok = newToken(token.RBRACE, l.ch)
 	case '"':
-		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		tok = l.readStringToken(token.TEMPLATE_HEAD, token.STRING)
 	case '[':
 		tok = newToken(token.LBRACKET, l.ch)
 	case ']':
 		tok = newToken(token.RBRACKET, l.ch)
 	case ':':
 		tok = newToken(token.COLON, l.ch)
+	case '&':
+		tok = l.newDoubleToken(token.BIT_AND, token.AND)
+	case '|':
+		tok = l.newDoubleToken(token.BIT_OR, token.OR)
+	case '^':
+		tok = newToken(token.BIT_XOR, l.ch)
+	case '~':
+		tok = newToken(token.BIT_NOT, l.ch)
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
@@ -95,25 +189,37 @@ func (l *Lexer) NextToken() token.Token {
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdent(tok.Literal)
+			tok.Pos, tok.End = start, l.currentPosition()
 			return tok
 		}
 		if isDigit(l.ch) {
-			tok.Literal = l.readNumber()
-			tok.Type = token.INT
+			tok.Literal, tok.Type = l.readNumber()
+			tok.Pos, tok.End = start, l.currentPosition()
 			return tok
 		}
 		tok = newToken(token.ILLEGAL, l.ch)
 	}
 	l.readChar()
+	tok.Pos, tok.End = start, l.currentPosition()
 	return tok
 }
 
-func (l *Lexer) peekChar() byte {
+// newDoubleToken returns a double token if the current character is
+// repeated, as in "&&", and a single token for the character otherwise.
+func (l *Lexer) newDoubleToken(single, double token.TokenType) token.Token {
+	if l.peekChar() != l.ch {
+		return newToken(single, l.ch)
+	}
+	l.readChar()
+	return token.Token{Type: double, Literal: string(double)}
+}
+
+func (l *Lexer) peekChar() rune {
 	if l.readPosition >= len(l.input) {
 		return 0
-	} else {
-		return l.input[l.readPosition]
 	}
+	r, _ := utf8.DecodeRuneInString(l.input[l.readPosition:])
+	return r
 }
 
 func (l *Lexer) readIdentifier() string {
@@ -124,39 +230,175 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
+// skipWhitespace skips whitespace and, unless comments are kept, comments.
+// An unterminated block comment is left for NextToken to report.
 func (l *Lexer) skipWhitespace() {
-	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
+	for {
+		switch {
+		case l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r':
+			l.readChar()
+		case l.ch == '/' && l.peekChar() == '/' && !l.keepComments:
+			l.readComment()
+		case l.ch == '/' && l.peekChar() == '*' && !l.keepComments &&
+			strings.Contains(l.input[l.position+2:], "*/"):
+			l.readComment()
+		default:
+			return
+		}
+	}
+}
+
+// readComment reads a "//" comment up to the end of the line or a "/* */"
+// comment up to and including its closing "*/". It reports false for a
+// block comment that is not closed before the end of input.
+func (l *Lexer) readComment() (string, bool) {
+	position := l.position
+	l.readChar()
+
+	if l.ch == '/' {
+		for l.ch != '\n' && l.ch != 0 {
+			l.readChar()
+		}
+		return l.input[position:l.position], true
+	}
+
+	l.readChar()
+	for !(l.ch == '*' && l.peekChar() == '/') {
+		if l.ch == 0 {
+			return l.input[position:l.position], false
+		}
 		l.readChar()
 	}
+	l.readChar()
+	l.readChar()
+	return l.input[position:l.position], true
 }
 
-func (l *Lexer) readNumber() string {
+func (l *Lexer) readNumber() (string, token.TokenType) {
 	position := l.position
+	tokenType := token.TokenType(token.INT)
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[position:l.position]
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
+		l.readChar()
+		for isDigit(l.ch) {
+			l.readChar()
+		}
+	}
+	return l.input[position:l.position], tokenType
+}
+
+// readStringToken reads the rest of a string, up to either an interpolated
+// "${", giving an open token, or the closing quote, giving a closed token.
+func (l *Lexer) readStringToken(open, closed token.TokenType) token.Token {
+	value, msg, interpolated := l.readString()
+	switch {
+	case msg != "":
+		return token.Token{Type: token.ERROR, Literal: msg}
+	case interpolated:
+		l.interpolations = append(l.interpolations, 0)
+		return token.Token{Type: open, Literal: value}
+	default:
+		return token.Token{Type: closed, Literal: value}
+	}
 }
 
-func (l *Lexer) readString() string {
-	pos := l.position + 1
+// readString reads a string literal and decodes its escape sequences. It
+// stops at the closing quote or at the '{' of an interpolated "${", which is
+// reported by interpolated. For a malformed literal it also returns a
+// message describing the first problem. The lexer is left at the end of
+// input if the string is not terminated.
+func (l *Lexer) readString() (value, msg string, interpolated bool) {
+	var out strings.Builder
+
 	for {
 		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
-			break
+		switch l.ch {
+		case '"':
+			return out.String(), msg, false
+		case 0:
+			return out.String(), "unterminated string", false
+		case '$':
+			if l.peekChar() == '{' {
+				l.readChar()
+				return out.String(), msg, true
+			}
+			out.WriteRune(l.ch)
+		case '\\':
+			l.readChar()
+			if l.ch == 0 {
+				return out.String(), "unterminated string", false
+			}
+			r, err := l.readEscape()
+			if err != "" && msg == "" {
+				msg = err
+			}
+			out.WriteRune(r)
+		default:
+			out.WriteRune(l.ch)
+		}
+	}
+}
+
+var escapes = map[rune]rune{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'0':  0,
+	'\\': '\\',
+	'"':  '"',
+	'$':  '$',
+}
+
+// readEscape decodes the escape sequence whose first character, after the
+// backslash, is the current one. \u{...} takes a hexadecimal code point.
+func (l *Lexer) readEscape() (rune, string) {
+	if r, ok := escapes[l.ch]; ok {
+		return r, ""
+	}
+	if l.ch != 'u' || l.peekChar() != '{' {
+		return l.ch, fmt.Sprintf("unknown escape sequence \\%c", l.ch)
+	}
+
+	l.readChar()
+	start := l.readPosition
+	for l.peekChar() != '}' {
+		if l.peekChar() == '"' || l.peekChar() == 0 {
+			return utf8.RuneError, "unterminated unicode escape"
 		}
+		l.readChar()
+	}
+	digits := l.input[start:l.readPosition]
+	l.readChar()
+
+	code, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil || !utf8.ValidRune(rune(code)) {
+		return utf8.RuneError, fmt.Sprintf("invalid unicode escape \\u{%s}", digits)
 	}
-	return l.input[pos:l.position]
+	return rune(code), ""
 }
 
-func isDigit(ch byte) bool {
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+func isLetter(ch rune) bool {
+	return unicode.IsLetter(ch) || ch == '_'
 }
 
-func newToken(tokenType token.TokenType, ch byte) token.Token {
+func newToken(tokenType token.TokenType, ch rune) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
+
+// newAssignToken returns the assign token, such as "+=" or "<=", if the
+// current character is followed by '=', and the plain operator otherwise.
+func (l *Lexer) newAssignToken(op, assign token.TokenType) token.Token {
+	if l.peekChar() != '=' {
+		return newToken(op, l.ch)
+	}
+	ch := l.ch
+	l.readChar()
+	return token.Token{Type: assign, Literal: string(ch) + string(l.ch)}
+}
@@ -89,7 +89,7 @@ func TestNextToken(t *testing.T) {
 		},
 		{
 			name:  "c",
-			input: `!-/*<>`,
+			input: `!-/ *<>`,
 			wanted: []wanted{
 				{token.BANG, "!"},
 				{token.MINUS, "-"},
@@ -120,6 +120,18 @@ func TestNextToken(t *testing.T) {
 				{token.NOT_EQ, "!="},
 			},
 		},
+		{
+			"float",
+			`3.14 10 0.5 1.foo`,
+			[]wanted{
+				{token.FLOAT, "3.14"},
+				{token.INT, "10"},
+				{token.FLOAT, "0.5"},
+				{token.INT, "1"},
+				{token.ILLEGAL, "."},
+				{token.IDENT, "foo"},
+			},
+		},
 		{
 			"string",
 			`"foobar"`,
@@ -134,6 +146,120 @@ func TestNextToken(t *testing.T) {
 				{token.STRING, "foo bar"},
 			},
 		},
+		{
+			"loops",
+			`while for in break continue`,
+			[]wanted{
+				{token.WHILE, "while"},
+				{token.FOR, "for"},
+				{token.IN, "in"},
+				{token.BREAK, "break"},
+				{token.CONTINUE, "continue"},
+			},
+		},
+		{
+			"logical",
+			`a && b || c & d`,
+			[]wanted{
+				{token.IDENT, "a"},
+				{token.AND, "&&"},
+				{token.IDENT, "b"},
+				{token.OR, "||"},
+				{token.IDENT, "c"},
+				{token.BIT_AND, "&"},
+				{token.IDENT, "d"},
+			},
+		},
+		{
+			"operators",
+			`<= >= % ** *= & | ^ << >> ~`,
+			[]wanted{
+				{token.LT_EQ, "<="},
+				{token.GT_EQ, ">="},
+				{token.PERCENT, "%"},
+				{token.POWER, "**"},
+				{token.ASTERISK_ASSIGN, "*="},
+				{token.BIT_AND, "&"},
+				{token.BIT_OR, "|"},
+				{token.BIT_XOR, "^"},
+				{token.SHL, "<<"},
+				{token.SHR, ">>"},
+				{token.BIT_NOT, "~"},
+			},
+		},
+		{
+			"comments",
+			"a // line comment\n/ b /* block\n * comment */ /= c /**/ // at EOF",
+			[]wanted{
+				{token.IDENT, "a"},
+				{token.SLASH, "/"},
+				{token.IDENT, "b"},
+				{token.SLASH_ASSIGN, "/="},
+				{token.IDENT, "c"},
+				{token.EOF, ""},
+			},
+		},
+		{
+			"escapes",
+			`"a\nb\t\"c\"\\" "\u{e9}\u{1F600}" "\q" "\u{110000}"`,
+			[]wanted{
+				{token.STRING, "a\nb\t\"c\"\\"},
+				{token.STRING, "é😀"},
+				{token.ERROR, "unknown escape sequence \\q"},
+				{token.ERROR, "invalid unicode escape \\u{110000}"},
+				{token.EOF, ""},
+			},
+		},
+		{
+			"unicode",
+			`let café = "naïve 世界";`,
+			[]wanted{
+				{token.LET, "let"},
+				{token.IDENT, "café"},
+				{token.ASSIGN, "="},
+				{token.STRING, "naïve 世界"},
+				{token.SEMICOLON, ";"},
+			},
+		},
+		{
+			"interpolation",
+			`"a ${b} c ${ {"k": "${d}"}["k"] }" "\${e}$"`,
+			[]wanted{
+				{token.TEMPLATE_HEAD, "a "},
+				{token.IDENT, "b"},
+				{token.TEMPLATE_MIDDLE, " c "},
+				{token.LBRACE, "{"},
+				{token.STRING, "k"},
+				{token.COLON, ":"},
+				{token.TEMPLATE_HEAD, ""},
+				{token.IDENT, "d"},
+				{token.TEMPLATE_TAIL, ""},
+				{token.RBRACE, "}"},
+				{token.LBRACKET, "["},
+				{token.STRING, "k"},
+				{token.RBRACKET, "]"},
+				{token.TEMPLATE_TAIL, ""},
+				{token.STRING, "${e}$"},
+				{token.EOF, ""},
+			},
+		},
+		{
+			"unterminated string",
+			`"abc`,
+			[]wanted{
+				{token.ERROR, "unterminated string"},
+				{token.EOF, ""},
+			},
+		},
+		{
+			"unterminated comment",
+			"a /* b",
+			[]wanted{
+				{token.IDENT, "a"},
+				{token.ERROR, "unterminated comment"},
+				{token.EOF, ""},
+			},
+		},
 	}
 
 	for _, tt := range testCases {
@@ -153,3 +279,117 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestNextTokenPosition(t *testing.T) {
+	input := "let x = 5;\n  x + \"ab\";"
+
+	tests := []struct {
+		expectedType   token.TokenType
+		expectedLine   int
+		expectedColumn int
+		expectedStart  int
+		expectedEnd    int
+	}{
+		{token.LET, 1, 1, 0, 3},
+		{token.IDENT, 1, 5, 4, 5},
+		{token.ASSIGN, 1, 7, 6, 7},
+		{token.INT, 1, 9, 8, 9},
+		{token.SEMICOLON, 1, 10, 9, 10},
+		{token.IDENT, 2, 3, 13, 14},
+		{token.PLUS, 2, 5, 15, 16},
+		{token.STRING, 2, 7, 17, 21},
+		{token.SEMICOLON, 2, 11, 21, 22},
+		{token.EOF, 2, 12, 22, 22},
+	}
+
+	l := NewWithFile("main.mk", input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Pos.File != "main.mk" {
+			t.Fatalf("tests[%d] - file wrong. expected=%q, got=%q",
+				i, "main.mk", tok.Pos.File)
+		}
+		if tok.Pos.Line != tt.expectedLine || tok.Pos.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - position wrong. expected=%d:%d, got=%d:%d",
+				i, tt.expectedLine, tt.expectedColumn, tok.Pos.Line, tok.Pos.Column)
+		}
+		if tok.Pos.Offset != tt.expectedStart || tok.End.Offset != tt.expectedEnd {
+			t.Fatalf("tests[%d] - span wrong. expected=[%d, %d), got=[%d, %d)",
+				i, tt.expectedStart, tt.expectedEnd, tok.Pos.Offset, tok.End.Offset)
+		}
+	}
+}
+
+func TestKeepComments(t *testing.T) {
+	input := "// head\nlet x = /* one */ 1; /* open"
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedLine    int
+		expectedColumn  int
+	}{
+		{token.COMMENT, "// head", 1, 1},
+		{token.LET, "let", 2, 1},
+		{token.IDENT, "x", 2, 5},
+		{token.ASSIGN, "=", 2, 7},
+		{token.COMMENT, "/* one */", 2, 9},
+		{token.INT, "1", 2, 19},
+		{token.SEMICOLON, ";", 2, 20},
+		{token.ERROR, "unterminated comment", 2, 22},
+		{token.EOF, "", 2, 29},
+	}
+
+	l := New(input)
+	l.KeepComments()
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - token wrong. expected=%q %q, got=%q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+		if tok.Pos.Line != tt.expectedLine || tok.Pos.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - position wrong. expected=%d:%d, got=%d:%d",
+				i, tt.expectedLine, tt.expectedColumn, tok.Pos.Line, tok.Pos.Column)
+		}
+	}
+}
+
+func TestNextTokenPositionUnicode(t *testing.T) {
+	l := New("\"é\" + ü")
+
+	tests := []struct {
+		expectedType   token.TokenType
+		expectedColumn int
+		expectedStart  int
+		expectedEnd    int
+	}{
+		{token.STRING, 1, 0, 4},
+		{token.PLUS, 5, 5, 6},
+		{token.IDENT, 7, 7, 9},
+		{token.EOF, 8, 9, 9},
+	}
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Pos.Column != tt.expectedColumn {
+			t.Errorf("tests[%d] - column wrong. expected=%d, got=%d",
+				i, tt.expectedColumn, tok.Pos.Column)
+		}
+		if tok.Pos.Offset != tt.expectedStart || tok.End.Offset != tt.expectedEnd {
+			t.Errorf("tests[%d] - span wrong. expected=[%d, %d), got=[%d, %d)",
+				i, tt.expectedStart, tt.expectedEnd, tok.Pos.Offset, tok.End.Offset)
+		}
+	}
+}
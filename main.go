@@ -1,12 +1,356 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
 	"monkey/repl"
+	"monkey/vm"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
+const (
+	exitOK           = 0
+	exitRuntimeError = 1
+	exitUsage        = 2
+	exitParseError   = 3
+	exitCompileError = 4
+)
+
+const usage = `usage:
+  monkey                      start the REPL, or run a script piped on stdin
+  monkey run [flags] <file>   run a script or .mkc file ("-" reads stdin)
+  monkey build [-o out] <file>
+                              compile a script to bytecode (default <file>.mkc)
+  monkey disasm [-optimize=false] <file>
+                              print the bytecode of a script or .mkc file
+  monkey -e <source>          run source given on the command line
+
+flags:
+  --engine=vm|eval            execution engine (default vm)
+`
+
 func main() {
-	fmt.Printf("Hi! This is Monkey programming language REPL")
-	repl.Start(os.Stdin, os.Stdout)
+	os.Exit(cli(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func cli(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("monkey", stderr)
+	engine := fs.String("engine", "vm", "")
+	source := fs.String("e", "", "")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *engine != "vm" && *engine != "eval" {
+		fmt.Fprintf(stderr, "unknown engine %q\n%s", *engine, usage)
+		return exitUsage
+	}
+
+	if isFlagSet(fs, "e") {
+		return execute("-e", *source, *engine, true, stdout, stderr)
+	}
+
+	args = fs.Args()
+	if len(args) == 0 {
+		if isTerminal(stdin) {
+			fmt.Fprintf(stdout, "Hi! This is Monkey programming language REPL\n")
+			repl.Start(stdin, stdout)
+			return exitOK
+		}
+		return runFile("-", *engine, stdin, stdout, stderr)
+	}
+
+	switch args[0] {
+	case "run":
+		return runCommand(args[1:], *engine, stdin, stdout, stderr)
+	case "build":
+		return buildCommand(args[1:], stdin, stderr)
+	case "disasm":
+		return disasmCommand(args[1:], stdin, stdout, stderr)
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n%s", args[0], usage)
+		return exitUsage
+	}
+}
+
+func runCommand(args []string, engine string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("run", stderr)
+	fs.StringVar(&engine, "engine", engine, "")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if engine != "vm" && engine != "eval" {
+		fmt.Fprintf(stderr, "unknown engine %q\n%s", engine, usage)
+		return exitUsage
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+
+	return runFile(fs.Arg(0), engine, stdin, stdout, stderr)
+}
+
+func buildCommand(args []string, stdin io.Reader, stderr io.Writer) int {
+	fs := newFlagSet("build", stderr)
+	output := fs.String("o", "", "")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+
+	path := fs.Arg(0)
+	name, src, err := readSource(path, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s\n", err)
+		return exitUsage
+	}
+	if compiler.IsBytecode(src) {
+		fmt.Fprintf(stderr, "%s is already compiled\n", name)
+		return exitUsage
+	}
+
+	bytecode, status := compile(name, string(src), true, stderr)
+	if status != exitOK {
+		return status
+	}
+	data, err := bytecode.MarshalBinary()
+	if err != nil {
+		fmt.Fprintf(stderr, "%s\n", err)
+		return exitCompileError
+	}
+
+	out := *output
+	if out == "" {
+		if path == "-" {
+			fmt.Fprintf(stderr, "-o is required when reading stdin\n")
+			return exitUsage
+		}
+		out = strings.TrimSuffix(path, filepath.Ext(path)) + ".mkc"
+	}
+	if err := os.WriteFile(out, data, 0o644); err != nil {
+		fmt.Fprintf(stderr, "%s\n", err)
+		return exitUsage
+	}
+	return exitOK
+}
+
+func disasmCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("disasm", stderr)
+	optimize := fs.Bool("optimize", true, "")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+
+	name, src, err := readSource(fs.Arg(0), stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s\n", err)
+		return exitUsage
+	}
+
+	var bytecode *compiler.Bytecode
+	var source string
+	if compiler.IsBytecode(src) {
+		bytecode = &compiler.Bytecode{}
+		if err := bytecode.UnmarshalBinary(src); err != nil {
+			fmt.Fprintf(stderr, "%s: %s\n", name, err)
+			return exitCompileError
+		}
+	} else {
+		source = string(src)
+		var status int
+		bytecode, status = compile(name, source, *optimize, stderr)
+		if status != exitOK {
+			return status
+		}
+	}
+
+	if err := bytecode.Disassemble(stdout, source); err != nil {
+		fmt.Fprintf(stderr, "%s: %s\n", name, err)
+		return exitCompileError
+	}
+	return exitOK
+}
+
+func runFile(path, engine string, stdin io.Reader, stdout, stderr io.Writer) int {
+	name, src, err := readSource(path, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s\n", err)
+		return exitUsage
+	}
+
+	if compiler.IsBytecode(src) {
+		if engine == "eval" {
+			fmt.Fprintf(stderr, "%s: bytecode can only be run by the vm engine\n", name)
+			return exitUsage
+		}
+		bytecode := &compiler.Bytecode{}
+		if err := bytecode.UnmarshalBinary(src); err != nil {
+			fmt.Fprintf(stderr, "%s: %s\n", name, err)
+			return exitCompileError
+		}
+		return runBytecode(bytecode, false, stdout, stderr)
+	}
+
+	return execute(name, string(src), engine, false, stdout, stderr)
+}
+
+// readSource reads the file at path, or stdin for "-", and returns the name
+// to use for it in messages along with its content.
+func readSource(path string, stdin io.Reader) (string, []byte, error) {
+	if path == "-" {
+		src, err := io.ReadAll(stdin)
+		return "<stdin>", src, err
+	}
+	src, err := os.ReadFile(path)
+	return path, src, err
+}
+
+// execute runs src with the given engine and returns the process exit code.
+// When printResult is set and src ends in an expression statement, its value
+// is written to stdout, as for "monkey -e".
+func execute(name, src, engine string, printResult bool, stdout, stderr io.Writer) int {
+	program, status := parse(name, src, stderr)
+	if status != exitOK {
+		return status
+	}
+	printResult = printResult && endsInExpression(program)
+
+	if engine != "eval" {
+		bytecode, status := compileProgram(program, true, stderr)
+		if status != exitOK {
+			return status
+		}
+		return runBytecode(bytecode, printResult, stdout, stderr)
+	}
+
+	env := object.NewEnvironment()
+	result := evaluator.Eval(program, env)
+	if errObj, ok := result.(*object.Error); ok {
+		fmt.Fprintf(stderr, "runtime error: %s\n", errObj.Message)
+		return exitRuntimeError
+	}
+
+	printValue(result, printResult, stdout)
+	return exitOK
+}
+
+func parse(name, src string, stderr io.Writer) (*ast.Program, int) {
+	l := lexer.NewWithFile(name, stripShebang(src))
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, msg := range p.Errors() {
+			fmt.Fprintf(stderr, "%s\n", msg)
+		}
+		return nil, exitParseError
+	}
+	return program, exitOK
+}
+
+// endsInExpression reports whether the last statement of program is an
+// expression statement. Only then does the program have a value to print:
+// the vm would otherwise show whatever it popped last.
+func endsInExpression(program *ast.Program) bool {
+	if len(program.Statements) == 0 {
+		return false
+	}
+	_, ok := program.Statements[len(program.Statements)-1].(*ast.ExpressionStatement)
+	return ok
+}
+
+func compile(name, src string, optimize bool, stderr io.Writer) (*compiler.Bytecode, int) {
+	program, status := parse(name, src, stderr)
+	if status != exitOK {
+		return nil, status
+	}
+	return compileProgram(program, optimize, stderr)
+}
+
+func compileProgram(program *ast.Program, optimize bool, stderr io.Writer) (*compiler.Bytecode, int) {
+	comp := compiler.New(compiler.WithOptimizations(optimize))
+	if err := comp.Compile(program); err != nil {
+		fmt.Fprintf(stderr, "%s\n", err)
+		return nil, exitCompileError
+	}
+	return comp.Bytecode(), exitOK
+}
+
+func runBytecode(bytecode *compiler.Bytecode, printResult bool, stdout, stderr io.Writer) int {
+	machine := vm.New(bytecode)
+	if err := machine.Run(context.Background()); err != nil {
+		if rtErr, ok := err.(*vm.RuntimeError); ok {
+			fmt.Fprint(stderr, rtErr.Traceback())
+		} else {
+			fmt.Fprintf(stderr, "runtime error: %s\n", err)
+		}
+		return exitRuntimeError
+	}
+
+	printValue(machine.LastPoppedStackElem(), printResult, stdout)
+	return exitOK
+}
+
+func printValue(result object.Object, printResult bool, stdout io.Writer) {
+	if printResult && result != nil && result.Type() != object.NULL_OBJ {
+		fmt.Fprintln(stdout, result.Inspect())
+	}
+}
+
+// stripShebang blanks out a leading "#!" line so scripts can be made
+// executable. The newline is kept so line numbers stay correct.
+func stripShebang(src string) string {
+	if !strings.HasPrefix(src, "#!") {
+		return src
+	}
+	if i := strings.IndexByte(src, '\n'); i >= 0 {
+		return src[i:]
+	}
+	return ""
+}
+
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprint(stderr, usage) }
+	return fs
+}
+
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
 }
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"monkey/code"
+	"monkey/compiler"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCLI(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("writing %s: %s", name, err)
+		}
+		return path
+	}
+
+	script := write("script.mk", "#!/usr/bin/env monkey\nlet x = 1 + 2;\nputs(x);\n")
+	order := write("order.mk", "let c = 0; let k = fn() { c = 10; 1 }; c += k(); puts(c)")
+	failing := write("failing.mk", "let f = fn(x) { x / 0 };\nf(1);\n")
+
+	compiled := filepath.Join(dir, "compiled.mkc")
+	if code, _, stderr := runCLI(t, []string{"build", "-o", compiled, script}, ""); code != exitOK {
+		t.Fatalf("build failed with exit code %d: %s", code, stderr)
+	}
+	data, err := os.ReadFile(compiled)
+	if err != nil {
+		t.Fatalf("reading %s: %s", compiled, err)
+	}
+	truncated := write("truncated.mkc", string(data[:len(data)-3]))
+	damagedData, err := (&compiler.Bytecode{Instructions: code.Instructions{byte(code.OpConstant)}}).MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %s", err)
+	}
+	damaged := write("damaged.mkc", string(damagedData))
+
+	tests := []struct {
+		name     string
+		args     []string
+		stdin    string
+		exitCode int
+		stdout   string
+		stderr   string
+	}{
+		{"-e prints the result", []string{"-e", "1 + 2"}, "", exitOK, "3\n", ""},
+		{"-e with eval engine", []string{"--engine=eval", "-e", `"a" + "b"`}, "", exitOK, "ab\n", ""},
+		{"-e prints no null", []string{"-e", "if (false) { 1 }"}, "", exitOK, "", ""},
+		{"stdin", nil, "puts(2 * 21)", exitOK, "42\n", ""},
+		{"run stdin", []string{"run", "-"}, "puts(7)", exitOK, "7\n", ""},
+		{"run skips shebang", []string{"run", script}, "", exitOK, "3\n", ""},
+		{"run with eval engine", []string{"run", "--engine=eval", script}, "", exitOK, "3\n", ""},
+		{"engine before run", []string{"--engine=eval", "run", script}, "", exitOK, "3\n", ""},
+		{"compound order vm", []string{"run", "--engine=vm", order}, "", exitOK, "1\n", ""},
+		{"compound order eval", []string{"run", "--engine=eval", order}, "", exitOK, "1\n", ""},
+		{"run bytecode", []string{"run", compiled}, "", exitOK, "3\n", ""},
+
+		{"runtime error vm", []string{"run", failing}, "", exitRuntimeError, "", "division by zero"},
+		{"runtime error eval", []string{"run", "--engine=eval", failing}, "", exitRuntimeError, "", "division by zero"},
+		{"uncaught exception", []string{"-e", `throw "boom"`}, "", exitRuntimeError, "", "boom"},
+		{"unknown identifier eval", []string{"--engine=eval", "-e", "y"}, "", exitRuntimeError, "", "identifier not found: y"},
+
+		{"unknown command", []string{"jump"}, "", exitUsage, "", `unknown command "jump"`},
+		{"unknown engine", []string{"--engine=js", "-e", "1"}, "", exitUsage, "", `unknown engine "js"`},
+		{"unknown flag", []string{"--fast"}, "", exitUsage, "", "usage:"},
+		{"run without file", []string{"run"}, "", exitUsage, "", "usage:"},
+		{"missing file", []string{"run", filepath.Join(dir, "missing.mk")}, "", exitUsage, "", "missing.mk"},
+		{"bytecode with eval", []string{"run", "--engine=eval", compiled}, "", exitUsage, "", "only be run by the vm engine"},
+		{"build bytecode", []string{"build", compiled}, "", exitUsage, "", "already compiled"},
+		{"build stdin without -o", []string{"build", "-"}, "1", exitUsage, "", "-o is required"},
+
+		{"parse error", []string{"-e", "let = 1;"}, "", exitParseError, "", "expected next token to be 'IDENT'"},
+		{"parse error eval", []string{"--engine=eval", "-e", "let = 1;"}, "", exitParseError, "", "expected next token to be 'IDENT'"},
+		{"parse error disasm", []string{"disasm", "-"}, "fn(", exitParseError, "", ""},
+
+		{"compile error", []string{"-e", "y"}, "", exitCompileError, "", "undefined variable y"},
+		{"truncated bytecode", []string{"run", truncated}, "", exitCompileError, "", "bytecode is truncated"},
+		{"truncated bytecode disasm", []string{"disasm", truncated}, "", exitCompileError, "", "bytecode is truncated"},
+		{"damaged bytecode", []string{"run", damaged}, "", exitCompileError, "", "truncated operands"},
+		{"damaged bytecode disasm", []string{"disasm", damaged}, "", exitCompileError, "", "truncated operands"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runCLI(t, tt.args, tt.stdin)
+			if code != tt.exitCode {
+				t.Errorf("wrong exit code. want=%d, got=%d (stderr: %q)", tt.exitCode, code, stderr)
+			}
+			if stdout != tt.stdout {
+				t.Errorf("wrong stdout. want=%q, got=%q", tt.stdout, stdout)
+			}
+			if !strings.Contains(stderr, tt.stderr) {
+				t.Errorf("stderr does not mention %q. got=%q", tt.stderr, stderr)
+			}
+			if tt.exitCode == exitOK && stderr != "" {
+				t.Errorf("unexpected stderr: %q", stderr)
+			}
+		})
+	}
+}
+
+// TestEnginesAgree runs programs on both engines, which have to print the
+// same thing.
+func TestEnginesAgree(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"for (x in [1, 2, 3]) { let y = [x, if (x == 2) { break } else { 0 }]; puts(y) }",
+			"[1, 0]\n",
+		},
+		{
+			"let a = [0]; let k = fn() { a[0] = 10; 1 }; a[0] += k(); puts(a[0])",
+			"1\n",
+		},
+		{
+			"fn() { let f = fn() { f = 5 }; f(); puts(f) }()",
+			"5\n",
+		},
+		{
+			"let i = 0; while (i < 5000) { i += 1; [1, if (true) { continue } else { 1 }] }; puts(i)",
+			"5000\n",
+		},
+		{
+			`let s = 0;
+			for (x in [1, 2, 3]) {
+				s = s + x * [1, if (true) { try { if (x == 2) { continue } } finally { s = s + 100 }; 2 }][1]
+			}
+			puts(s)`,
+			"108\n",
+		},
+	}
+
+	for _, tt := range tests {
+		for _, engine := range []string{"vm", "eval"} {
+			code, stdout, stderr := runCLI(t, []string{"run", "--engine=" + engine, "-"}, tt.input)
+			if code != exitOK || stdout != tt.expected {
+				t.Errorf("%s engine: %q\nwant=%q, got=%q (exit code %d, stderr %q)",
+					engine, tt.input, tt.expected, stdout, code, stderr)
+			}
+		}
+	}
+}
+
+// TestPrintResult checks that -e prints the value of a program on both
+// engines only when it ends in an expression statement.
+func TestPrintResult(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1; 2", "2\n"},
+		{"let x = 5; x = 6", "6\n"},
+		{"let x = 5", ""},
+		{"for (c in [1, 2]) {}", ""},
+		{"while (false) {}", ""},
+		{"let i = 0; while (i < 3) { i += 1; i }", ""},
+		{"try { 1 / 0 } catch (e) { e }", ""},
+		{"try { 1 / 0 } catch (e) { 2 }; 3", "3\n"},
+		{"puts(1)", "1\n"},
+	}
+
+	for _, tt := range tests {
+		for _, engine := range []string{"vm", "eval"} {
+			code, stdout, stderr := runCLI(t, []string{"--engine=" + engine, "-e", tt.input}, "")
+			if code != exitOK || stdout != tt.expected {
+				t.Errorf("%s engine: %q\nwant=%q, got=%q (exit code %d, stderr %q)",
+					engine, tt.input, tt.expected, stdout, code, stderr)
+			}
+		}
+	}
+}
+
+func TestDisasm(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "double.mk")
+	if err := os.WriteFile(script, []byte("let double = fn(x) { x * 2 };\ndouble(3);\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	compiled := filepath.Join(dir, "double.mkc")
+	if code, _, stderr := runCLI(t, []string{"build", "-o", compiled, script}, ""); code != exitOK {
+		t.Fatalf("build failed with exit code %d: %s", code, stderr)
+	}
+
+	tests := []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{"disasm", script}, []string{"== main", "let double = fn(x) { x * 2 };", "; fn double", "== constant"}},
+		{[]string{"disasm", "-optimize=false", script}, []string{"== main", "double(3);"}},
+		{[]string{"disasm", compiled}, []string{"== main", "; fn double", "== constant"}},
+	}
+	for _, tt := range tests {
+		code, stdout, stderr := runCLI(t, tt.args, "")
+		if code != exitOK || stderr != "" {
+			t.Errorf("%v: exit code %d, stderr %q", tt.args, code, stderr)
+			continue
+		}
+		for _, want := range tt.expected {
+			if !strings.Contains(stdout, want) {
+				t.Errorf("%v: listing does not contain %q:\n%s", tt.args, want, stdout)
+			}
+		}
+	}
+}
+
+func TestBuildDefaultOutput(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "hello.mk")
+	if err := os.WriteFile(script, []byte(`puts("hello")`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if code, _, stderr := runCLI(t, []string{"build", script}, ""); code != exitOK {
+		t.Fatalf("build failed with exit code %d: %s", code, stderr)
+	}
+	code, stdout, stderr := runCLI(t, []string{"run", filepath.Join(dir, "hello.mkc")}, "")
+	if code != exitOK || stdout != "hello\n" {
+		t.Errorf("running hello.mkc: exit code %d, stdout %q, stderr %q", code, stdout, stderr)
+	}
+}
+
+// runCLI runs cli with args and stdin and returns its exit code and output.
+// puts writes to os.Stdout rather than to the writer given to cli, so
+// os.Stdout is captured too and put in front of what cli wrote.
+func runCLI(t *testing.T, args []string, stdin string) (int, string, string) {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe: %s", err)
+	}
+	captured := make(chan string)
+	go func() {
+		var buf bytes.Buffer
+		io.Copy(&buf, r)
+		captured <- buf.String()
+	}()
+	saved := os.Stdout
+	os.Stdout = w
+
+	var stdout, stderr bytes.Buffer
+	code := cli(args, strings.NewReader(stdin), &stdout, &stderr)
+
+	os.Stdout = saved
+	w.Close()
+	return code, <-captured + stdout.String(), stderr.String()
+}
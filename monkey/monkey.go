@@ -0,0 +1,121 @@
+// Package monkey embeds Monkey in Go programs. It compiles source code to
+// bytecode and runs it on the virtual machine of package vm:
+//
+//	program, err := monkey.Compile(`let add = fn(a, b) { a + b };`)
+//	...
+//	machine, err := program.Run(ctx)
+//	...
+//	add, _ := machine.Global("add")
+//	sum, err := machine.Call(ctx, add, &object.Integer{Value: 1}, &object.Integer{Value: 2})
+package monkey
+
+import (
+	"context"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/vm"
+	"strings"
+)
+
+// ParseError is returned by Compile when the source does not parse.
+type ParseError struct {
+	Errors []string
+}
+
+func (e *ParseError) Error() string { return strings.Join(e.Errors, "\n") }
+
+// Program is a compiled Monkey program.
+type Program struct {
+	bytecode *compiler.Bytecode
+	symbols  *compiler.SymbolTable
+	builtins *object.Registry
+}
+
+// Option configures the compilation of a Program.
+type Option func(*Program)
+
+// WithBuiltins makes the program see the builtins of r, which can hold
+// functions of the host, instead of the standard ones.
+func WithBuiltins(r *object.Registry) Option {
+	return func(p *Program) { p.builtins = r }
+}
+
+// Compile parses and compiles src with optimizations enabled. It fails with
+// a *ParseError when src does not parse and with the error of the compiler
+// when it does not compile.
+func Compile(src string, opts ...Option) (*Program, error) {
+	prog := &Program{symbols: compiler.NewSymbolTable()}
+	for _, opt := range opts {
+		opt(prog)
+	}
+	if prog.builtins == nil {
+		prog.builtins = object.NewDefaultRegistry()
+	}
+	for i, def := range prog.builtins.Definitions() {
+		prog.symbols.DefineBuiltin(i, def.Name)
+	}
+
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, &ParseError{Errors: p.Errors()}
+	}
+
+	comp := compiler.NewWithState(prog.symbols, []object.Object{}, compiler.WithOptimizations(true))
+	if err := comp.Compile(program); err != nil {
+		return nil, err
+	}
+	prog.bytecode = comp.Bytecode()
+	return prog, nil
+}
+
+// Bytecode returns the bytecode of the program.
+func (p *Program) Bytecode() *compiler.Bytecode {
+	return p.bytecode
+}
+
+// Run runs the program on a new VM configured by opts and returns the VM,
+// which keeps the globals of the program. Errors are returned as
+// *vm.RuntimeError.
+func (p *Program) Run(ctx context.Context, opts ...vm.Option) (*VM, error) {
+	globals := make([]object.Object, vm.GlobalsSize)
+	opts = append([]vm.Option{vm.WithBuiltins(p.builtins)}, opts...)
+	machine := vm.NewWithGlobalsStore(p.bytecode, globals, opts...)
+	if err := machine.Run(ctx); err != nil {
+		return nil, err
+	}
+	return &VM{vm: machine, globals: globals, symbols: p.symbols}, nil
+}
+
+// VM is a virtual machine that has run a Program.
+type VM struct {
+	vm      *vm.VM
+	globals []object.Object
+	symbols *compiler.SymbolTable
+}
+
+// Result returns the value of the last expression statement the program
+// ran.
+func (m *VM) Result() object.Object {
+	return m.vm.LastPoppedStackElem()
+}
+
+// Global returns the value of the global variable name. It reports false
+// when the program does not define name or never assigned it.
+func (m *VM) Global(name string) (object.Object, bool) {
+	symbol, ok := m.symbols.Resolve(name)
+	if !ok || symbol.Scope != compiler.GlobalScope {
+		return nil, false
+	}
+	value := m.globals[symbol.Index]
+	return value, value != nil
+}
+
+// Call calls fn, usually an *object.Closure taken from Global, with args and
+// returns its result. The call sees the globals left by the program and can
+// change them. Errors are returned as *vm.RuntimeError.
+func (m *VM) Call(ctx context.Context, fn object.Object, args ...object.Object) (object.Object, error) {
+	return m.vm.Call(ctx, fn, args...)
+}
@@ -0,0 +1,231 @@
+package monkey
+
+import (
+	"context"
+	"errors"
+	"monkey/object"
+	"monkey/vm"
+	"testing"
+)
+
+func TestCompileErrors(t *testing.T) {
+	_, err := Compile("let = 5;")
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || len(parseErr.Errors) == 0 {
+		t.Errorf("expected a *ParseError. got=%T (%v)", err, err)
+	}
+
+	if _, err := Compile("undefinedName"); err == nil {
+		t.Errorf("expected a compile error")
+	}
+}
+
+func TestRunAndGlobals(t *testing.T) {
+	program, err := Compile(`
+	let name = "monkey";
+	let counter = 0;
+	let unset = if (false) { 1 };
+	counter += 2;
+	counter * 10
+	`)
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+
+	machine, err := program.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+
+	if result, ok := machine.Result().(*object.Integer); !ok || result.Value != 20 {
+		t.Errorf("wrong result. got=%v", machine.Result())
+	}
+	if name, ok := machine.Global("name"); !ok || name.Inspect() != "monkey" {
+		t.Errorf("wrong value for name. got=%v (%t)", name, ok)
+	}
+	if counter, ok := machine.Global("counter"); !ok || counter.Inspect() != "2" {
+		t.Errorf("wrong value for counter. got=%v (%t)", counter, ok)
+	}
+	if unset, ok := machine.Global("unset"); !ok || unset.Type() != object.NULL_OBJ {
+		t.Errorf("wrong value for unset. got=%v (%t)", unset, ok)
+	}
+	for _, name := range []string{"missing", "len"} {
+		if value, ok := machine.Global(name); ok {
+			t.Errorf("Global(%q) should not be found. got=%v", name, value)
+		}
+	}
+}
+
+func TestCall(t *testing.T) {
+	program, err := Compile(`
+	let total = 0;
+	let add = fn(a, b) { a + b };
+	let record = fn(n) { total += n; total };
+	let makeAdder = fn(x) { fn(y) { x + y } };
+	let countdown = fn(n) { if (n == 0) { "done" } else { countdown(n - 1) } };
+	let fail = fn() { 1 + "a" };
+	`)
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	machine, err := program.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+	ctx := context.Background()
+
+	global := func(name string) object.Object {
+		fn, ok := machine.Global(name)
+		if !ok {
+			t.Fatalf("global %q not found", name)
+		}
+		return fn
+	}
+	expectCall := func(fn object.Object, expected string, args ...object.Object) {
+		t.Helper()
+		result, err := machine.Call(ctx, fn, args...)
+		if err != nil {
+			t.Fatalf("call error: %s", err)
+		}
+		if result.Inspect() != expected {
+			t.Errorf("wrong result. want=%s, got=%s", expected, result.Inspect())
+		}
+	}
+
+	expectCall(global("add"), "3", &object.Integer{Value: 1}, &object.Integer{Value: 2})
+	expectCall(global("record"), "5", &object.Integer{Value: 5})
+	expectCall(global("record"), "12", &object.Integer{Value: 7})
+	if total := global("total"); total.Inspect() != "12" {
+		t.Errorf("wrong total. got=%s", total.Inspect())
+	}
+
+	adder, err := machine.Call(ctx, global("makeAdder"), &object.Integer{Value: 10})
+	if _, ok := adder.(*object.Closure); !ok || err != nil {
+		t.Fatalf("makeAdder did not return a closure. got=%v (%v)", adder, err)
+	}
+	expectCall(adder, "15", &object.Integer{Value: 5})
+	expectCall(global("countdown"), "done", &object.Integer{Value: 100000})
+	expectCall(object.GetBuiltinByName("len"), "4", &object.String{Value: "four"})
+
+	if _, err := machine.Call(ctx, global("fail")); err == nil || err.Error() != "unsupported types for binary operation: INTEGER STRING" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+	if _, err := machine.Call(ctx, global("add"), &object.Integer{Value: 1}); err == nil || err.Error() != "wrong number of arguments: want=2, got=1" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+	if _, err := machine.Call(ctx, &object.Integer{Value: 1}); err == nil {
+		t.Errorf("expected an error calling an integer")
+	}
+
+	// failed calls leave the VM usable
+	expectCall(global("add"), "42", &object.Integer{Value: 20}, &object.Integer{Value: 22})
+}
+
+func TestCallBudgets(t *testing.T) {
+	program, err := Compile("let loop = fn() { while (true) { } };")
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	machine, err := program.Run(context.Background(), vm.WithMaxInstructions(10000))
+	if err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+
+	loop, _ := machine.Global("loop")
+	if _, err := machine.Call(context.Background(), loop); !errors.Is(err, vm.ErrBudgetExceeded) {
+		t.Errorf("wrong error. got=%v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := machine.Call(ctx, loop); !errors.Is(err, vm.ErrTimeout) {
+		t.Errorf("wrong error. got=%v", err)
+	}
+}
+
+func TestWithBuiltins(t *testing.T) {
+	newInterpreter := func(name string, value int64) *Program {
+		r := object.NewDefaultRegistry()
+		r.Register(name, 0, func(args ...object.Object) object.Object {
+			return &object.Integer{Value: value}
+		}, "")
+
+		program, err := Compile(name+"() + len([1])", WithBuiltins(r))
+		if err != nil {
+			t.Fatalf("compile error: %s", err)
+		}
+		return program
+	}
+
+	tests := []struct {
+		program  *Program
+		expected string
+	}{
+		{newInterpreter("answer", 42), "43"},
+		{newInterpreter("zero", 0), "1"},
+	}
+
+	for _, tt := range tests {
+		machine, err := tt.program.Run(context.Background())
+		if err != nil {
+			t.Fatalf("run error: %s", err)
+		}
+		if machine.Result().Inspect() != tt.expected {
+			t.Errorf("wrong result. want=%s, got=%s", tt.expected, machine.Result().Inspect())
+		}
+	}
+
+	if _, err := Compile("answer()"); err == nil {
+		t.Errorf("host builtins leaked into the default registry")
+	}
+}
+
+func TestGoValues(t *testing.T) {
+	type user struct {
+		Name  string   `monkey:"name"`
+		Roles []string `monkey:"roles"`
+	}
+	users := map[string]user{"ann": {Name: "Ann", Roles: []string{"admin"}}}
+
+	r := object.NewDefaultRegistry()
+	r.RegisterFunc("lookup", func(id string) (user, error) {
+		u, ok := users[id]
+		if !ok {
+			return user{}, errors.New("no user " + id)
+		}
+		return u, nil
+	}, "returns the user with the given id")
+
+	program, err := Compile(`
+	let u = lookup("ann");
+	let missing = "";
+	try { lookup("bob") } catch (e) { missing = e }
+	let describe = fn(u) { {"name": u["name"] + "!", "roles": push(u["roles"], "user")} };
+	`, WithBuiltins(r))
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	machine, err := program.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+
+	if missing, _ := machine.Global("missing"); missing.Inspect() != "no user bob" {
+		t.Errorf("wrong error. got=%s", missing.Inspect())
+	}
+
+	u, _ := machine.Global("u")
+	describe, _ := machine.Global("describe")
+	result, err := machine.Call(context.Background(), describe, u)
+	if err != nil {
+		t.Fatalf("call error: %s", err)
+	}
+
+	var described user
+	if err := object.ToGo(result, &described); err != nil {
+		t.Fatalf("ToGo failed: %s", err)
+	}
+	if described.Name != "Ann!" || len(described.Roles) != 2 || described.Roles[1] != "user" {
+		t.Errorf("wrong result. got=%+v", described)
+	}
+}
@@ -1,13 +1,20 @@
 package object
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
 
-var Builtins = []struct {
-	Name    string
-	Builtin *Builtin
-}{
+// Builtins are the builtin functions every Registry made by
+// NewDefaultRegistry starts with, in the order of their indexes. Arity is
+// -1 for functions taking any number of arguments.
+var Builtins = []BuiltinDef{
 	{
-		Name: "len",
+		Name:  "len",
+		Arity: 1,
+		Doc:   "returns the number of characters of a string or elements of an array",
 		Builtin: &Builtin{
 			Fn: func(args ...Object) Object {
 				if len(args) != 1 {
@@ -17,7 +24,7 @@ var Builtins = []struct {
 
 				switch arg := args[0].(type) {
 				case *String:
-					return &Integer{Value: int64(len(arg.Value))}
+					return &Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
 				case *Array:
 					return &Integer{Value: int64(len(arg.Elements))}
 				default:
@@ -28,7 +35,9 @@ var Builtins = []struct {
 		},
 	},
 	{
-		Name: "puts",
+		Name:  "puts",
+		Arity: -1,
+		Doc:   "prints its arguments, one per line",
 		Builtin: &Builtin{
 			Fn: func(args ...Object) Object {
 				for _, arg := range args {
@@ -39,7 +48,9 @@ var Builtins = []struct {
 		},
 	},
 	{
-		Name: "first",
+		Name:  "first",
+		Arity: 1,
+		Doc:   "returns the first element of an array",
 		Builtin: &Builtin{
 			Fn: func(args ...Object) Object {
 				if len(args) != 1 {
@@ -61,7 +72,9 @@ var Builtins = []struct {
 		},
 	},
 	{
-		Name: "last",
+		Name:  "last",
+		Arity: 1,
+		Doc:   "returns the last element of an array",
 		Builtin: &Builtin{
 			Fn: func(args ...Object) Object {
 				if len(args) != 1 {
@@ -84,7 +97,9 @@ var Builtins = []struct {
 		},
 	},
 	{
-		Name: "rest",
+		Name:  "rest",
+		Arity: 1,
+		Doc:   "returns a new array holding all the elements of an array but the first",
 		Builtin: &Builtin{
 			Fn: func(args ...Object) Object {
 				if len(args) != 1 {
@@ -109,7 +124,9 @@ var Builtins = []struct {
 		},
 	},
 	{
-		Name: "push",
+		Name:  "push",
+		Arity: 2,
+		Doc:   "returns a new array holding the elements of an array followed by a value",
 		Builtin: &Builtin{
 			Fn: func(args ...Object) Object {
 				if len(args) != 2 {
@@ -132,6 +149,64 @@ var Builtins = []struct {
 			},
 		},
 	},
+	{
+		Name:  "int",
+		Arity: 1,
+		Doc:   "converts a float or a string to an integer",
+		Builtin: &Builtin{
+			Fn: func(args ...Object) Object {
+				if len(args) != 1 {
+					return newError("wrong number of arguments. got=%d, want=1",
+						len(args))
+				}
+
+				switch arg := args[0].(type) {
+				case *Integer:
+					return arg
+				case *Float:
+					return &Integer{Value: int64(arg.Value)}
+				case *String:
+					value, err := strconv.ParseInt(strings.TrimSpace(arg.Value), 10, 64)
+					if err != nil {
+						return newError("could not convert %q to INTEGER", arg.Value)
+					}
+					return &Integer{Value: value}
+				default:
+					return newError("argument to `int` not supported, got %s",
+						args[0].Type())
+				}
+			},
+		},
+	},
+	{
+		Name:  "float",
+		Arity: 1,
+		Doc:   "converts an integer or a string to a float",
+		Builtin: &Builtin{
+			Fn: func(args ...Object) Object {
+				if len(args) != 1 {
+					return newError("wrong number of arguments. got=%d, want=1",
+						len(args))
+				}
+
+				switch arg := args[0].(type) {
+				case *Integer:
+					return &Float{Value: float64(arg.Value)}
+				case *Float:
+					return arg
+				case *String:
+					value, err := strconv.ParseFloat(strings.TrimSpace(arg.Value), 64)
+					if err != nil {
+						return newError("could not convert %q to FLOAT", arg.Value)
+					}
+					return &Float{Value: value}
+				default:
+					return newError("argument to `float` not supported, got %s",
+						args[0].Type())
+				}
+			},
+		},
+	},
 }
 
 func newError(format string, a ...any) *Error {
@@ -1,8 +1,13 @@
 package object
 
+import "context"
+
 type Environment struct {
 	store map[string]Object
 	outer *Environment
+
+	// ctx is set on the environment a program is evaluated in, see Context
+	ctx context.Context
 }
 
 func NewEnclosedEnvironment(outer *Environment) *Environment {
@@ -28,3 +33,33 @@ func (e *Environment) Set(name string, val Object) Object {
 	e.store[name] = val
 	return val
 }
+
+// Assign updates an existing binding in the innermost environment that
+// defines name. It reports false if name is not bound anywhere.
+func (e *Environment) Assign(name string, val Object) bool {
+	if _, ok := e.store[name]; ok {
+		e.store[name] = val
+		return true
+	}
+	if e.outer != nil {
+		return e.outer.Assign(name, val)
+	}
+	return false
+}
+
+// SetContext sets the context that evaluation in e, and in the environments
+// enclosed by it, stops at once it is done.
+func (e *Environment) SetContext(ctx context.Context) {
+	e.ctx = ctx
+}
+
+// Context returns the context set on e or on the closest environment
+// enclosing it, or context.Background if there is none.
+func (e *Environment) Context() context.Context {
+	for env := e; env != nil; env = env.outer {
+		if env.ctx != nil {
+			return env.ctx
+		}
+	}
+	return context.Background()
+}
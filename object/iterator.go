@@ -0,0 +1,53 @@
+package object
+
+import "sort"
+
+// Iterator walks the elements of an array, the characters of a string or the
+// keys of a hash. It backs for-in loops in both the evaluator and the VM.
+type Iterator struct {
+	items []Object
+	next  int
+}
+
+func (it *Iterator) Type() ObjectType { return ITERATOR_OBJ }
+func (it *Iterator) Inspect() string  { return "iterator" }
+
+// NewIterator returns an iterator over obj, or false if obj is not iterable.
+// Hash keys are visited in the order of their Inspect output so that loops
+// over a hash are deterministic.
+func NewIterator(obj Object) (*Iterator, bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		return &Iterator{items: obj.Elements}, true
+	case *String:
+		items := make([]Object, 0, len(obj.Value))
+		for _, r := range obj.Value {
+			items = append(items, &String{Value: string(r)})
+		}
+		return &Iterator{items: items}, true
+	case *Hash:
+		items := make([]Object, 0, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			items = append(items, pair.Key)
+		}
+		sort.Slice(items, func(i, j int) bool {
+			if items[i].Type() != items[j].Type() {
+				return items[i].Type() < items[j].Type()
+			}
+			return items[i].Inspect() < items[j].Inspect()
+		})
+		return &Iterator{items: items}, true
+	default:
+		return nil, false
+	}
+}
+
+// Next returns the next item, or false once the iterator is exhausted.
+func (it *Iterator) Next() (Object, bool) {
+	if it.next >= len(it.items) {
+		return nil, false
+	}
+	item := it.items[it.next]
+	it.next++
+	return item, true
+}
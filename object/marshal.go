@@ -0,0 +1,527 @@
+package object
+
+import (
+	"fmt"
+	"reflect"
+)
+
+var (
+	objectType = reflect.TypeOf((*Object)(nil)).Elem()
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// FromGo converts a Go value to an Object:
+//
+//   - nil, and nil pointers, maps and slices, become *Null
+//   - bools, integers, floats and strings become *Boolean, *Integer,
+//     *Float and *String
+//   - slices and arrays become *Array
+//   - maps whose keys convert to a hashable object become *Hash
+//   - structs become a *Hash keyed by the names of their exported fields, or
+//     by the name given by a `monkey:"name"` tag; fields tagged
+//     `monkey:"-"` are left out
+//   - pointers become the object for the value they point to
+//   - functions become a *Builtin, see WrapFunc
+//
+// Objects are returned as they are. Other values, such as channels, and
+// values that refer to themselves are an error.
+func FromGo(v any) (Object, error) {
+	return fromGo(reflect.ValueOf(v), goVisiting{})
+}
+
+// goReference identifies the pointer, map or slice v: the same memory seen
+// through another type or as a slice of another length is another value.
+type goReference struct {
+	typ reflect.Type
+	ptr uintptr
+	len int
+}
+
+func referenceOf(v reflect.Value) goReference {
+	ref := goReference{typ: v.Type(), ptr: v.Pointer()}
+	if v.Kind() == reflect.Slice {
+		ref.len = v.Len()
+	}
+	return ref
+}
+
+// goVisiting holds the pointers, maps and slices a conversion is inside of,
+// so that one referring to itself is reported instead of recursing forever.
+type goVisiting map[goReference]bool
+
+// enter marks v as being converted. It fails if v is already being
+// converted; otherwise the caller has to call leave once done with v.
+func (vs goVisiting) enter(v reflect.Value) error {
+	ref := referenceOf(v)
+	if vs[ref] {
+		return fmt.Errorf("cannot convert %s: it refers to itself", v.Type())
+	}
+	vs[ref] = true
+	return nil
+}
+
+func (vs goVisiting) leave(v reflect.Value) {
+	delete(vs, referenceOf(v))
+}
+
+func fromGo(v reflect.Value, vs goVisiting) (Object, error) {
+	if !v.IsValid() {
+		return NULL, nil
+	}
+	if v.Type().Implements(objectType) {
+		if v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return NULL, nil
+			}
+		}
+		switch obj := v.Interface().(Object).(type) {
+		case *Boolean:
+			if obj.Value {
+				return TRUE, nil
+			}
+			return FALSE, nil
+		case *Null:
+			return NULL, nil
+		default:
+			return obj, nil
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return TRUE, nil
+		}
+		return FALSE, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > 1<<63-1 {
+			return nil, fmt.Errorf("cannot convert %d to INTEGER: out of range", v.Uint())
+		}
+		return &Integer{Value: int64(v.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Float{Value: v.Float()}, nil
+	case reflect.String:
+		return &String{Value: v.String()}, nil
+	case reflect.Interface:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return fromGo(v.Elem(), vs)
+	case reflect.Pointer:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		if err := vs.enter(v); err != nil {
+			return nil, err
+		}
+		defer vs.leave(v)
+		return fromGo(v.Elem(), vs)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice {
+			if v.IsNil() {
+				return NULL, nil
+			}
+			if err := vs.enter(v); err != nil {
+				return nil, err
+			}
+			defer vs.leave(v)
+		}
+		elements := make([]Object, v.Len())
+		for i := range elements {
+			element, err := fromGo(v.Index(i), vs)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = element
+		}
+		return &Array{Elements: elements}, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		if err := vs.enter(v); err != nil {
+			return nil, err
+		}
+		defer vs.leave(v)
+		hash := &Hash{Pairs: make(map[HashKey]HashPair, v.Len())}
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := setPair(hash, iter.Key(), iter.Value(), vs); err != nil {
+				return nil, err
+			}
+		}
+		return hash, nil
+	case reflect.Struct:
+		hash := &Hash{Pairs: map[HashKey]HashPair{}}
+		for _, field := range structFields(v.Type()) {
+			value, err := fromGo(v.FieldByIndex(field.index), vs)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", field.name, err)
+			}
+			key := &String{Value: field.name}
+			hash.Pairs[key.HashKey()] = HashPair{Key: key, Value: value}
+		}
+		return hash, nil
+	case reflect.Func:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return WrapFunc(v.Interface())
+	}
+	return nil, fmt.Errorf("cannot convert values of type %s", v.Type())
+}
+
+func setPair(hash *Hash, k, v reflect.Value, vs goVisiting) error {
+	key, err := fromGo(k, vs)
+	if err != nil {
+		return err
+	}
+	hashable, ok := key.(Hashable)
+	if !ok {
+		return fmt.Errorf("unusable as hash key: %s", key.Type())
+	}
+	value, err := fromGo(v, vs)
+	if err != nil {
+		return err
+	}
+	hash.Pairs[hashable.HashKey()] = HashPair{Key: key, Value: value}
+	return nil
+}
+
+type structField struct {
+	name  string
+	index []int
+}
+
+// structFields returns the fields of t that FromGo and ToGo convert, with
+// the hash key they are stored under.
+func structFields(t reflect.Type) []structField {
+	var fields []structField
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || len(f.Index) > 1 {
+			continue
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("monkey"); ok {
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				name = tag
+			}
+		}
+		fields = append(fields, structField{name: name, index: f.Index})
+	}
+	return fields
+}
+
+// ToGo stores obj in the value target points to, following the rules of
+// FromGo the other way around. Integers also convert to floats, *Null to
+// the zero value, and anything to an Object. A target of type any receives
+// int64, float64, string, bool, nil, []any, map[string]any when all the keys
+// of a hash are strings and map[any]any otherwise; functions stay Objects.
+// A nil obj and arrays or hashes that contain themselves are errors.
+func ToGo(obj Object, target any) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("ToGo needs a non-nil pointer, got %T", target)
+	}
+	return toGo(obj, v.Elem(), visiting{})
+}
+
+// visiting holds the arrays and hashes a conversion is inside of, so that
+// one containing itself is reported instead of recursing forever.
+type visiting map[Object]bool
+
+// enter marks obj as being converted if it is an array or a hash. It fails
+// if obj is already being converted; otherwise the caller has to call
+// leave once done with the elements of obj.
+func (vs visiting) enter(obj Object) error {
+	switch obj.(type) {
+	case *Array, *Hash:
+		if vs[obj] {
+			return fmt.Errorf("cannot convert %s: it contains itself", obj.Type())
+		}
+		vs[obj] = true
+	}
+	return nil
+}
+
+func (vs visiting) leave(obj Object) {
+	delete(vs, obj)
+}
+
+func toGo(obj Object, v reflect.Value, vs visiting) error {
+	if obj == nil {
+		return fmt.Errorf("cannot convert nil to %s", v.Type())
+	}
+	t := v.Type()
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		natural, err := toNatural(obj, vs)
+		if err != nil {
+			return err
+		}
+		if natural == nil {
+			v.SetZero()
+		} else {
+			v.Set(reflect.ValueOf(natural))
+		}
+		return nil
+	}
+	if reflect.TypeOf(obj).AssignableTo(t) {
+		v.Set(reflect.ValueOf(obj))
+		return nil
+	}
+	if _, ok := obj.(*Null); ok {
+		v.SetZero()
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		if b, ok := obj.(*Boolean); ok {
+			v.SetBool(b.Value)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := obj.(*Integer); ok {
+			if v.OverflowInt(i.Value) {
+				return fmt.Errorf("cannot convert %d to %s: out of range", i.Value, t)
+			}
+			v.SetInt(i.Value)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if i, ok := obj.(*Integer); ok {
+			if i.Value < 0 || v.OverflowUint(uint64(i.Value)) {
+				return fmt.Errorf("cannot convert %d to %s: out of range", i.Value, t)
+			}
+			v.SetUint(uint64(i.Value))
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		switch n := obj.(type) {
+		case *Float:
+			v.SetFloat(n.Value)
+			return nil
+		case *Integer:
+			v.SetFloat(float64(n.Value))
+			return nil
+		}
+	case reflect.String:
+		if s, ok := obj.(*String); ok {
+			v.SetString(s.Value)
+			return nil
+		}
+	case reflect.Pointer:
+		elem := reflect.New(t.Elem())
+		if err := toGo(obj, elem.Elem(), vs); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	case reflect.Slice:
+		if arr, ok := obj.(*Array); ok {
+			if err := vs.enter(arr); err != nil {
+				return err
+			}
+			defer vs.leave(arr)
+			slice := reflect.MakeSlice(t, len(arr.Elements), len(arr.Elements))
+			for i, element := range arr.Elements {
+				if err := toGo(element, slice.Index(i), vs); err != nil {
+					return fmt.Errorf("index %d: %w", i, err)
+				}
+			}
+			v.Set(slice)
+			return nil
+		}
+	case reflect.Array:
+		if arr, ok := obj.(*Array); ok {
+			if err := vs.enter(arr); err != nil {
+				return err
+			}
+			defer vs.leave(arr)
+			if len(arr.Elements) != t.Len() {
+				return fmt.Errorf("cannot convert ARRAY of %d elements to %s", len(arr.Elements), t)
+			}
+			for i, element := range arr.Elements {
+				if err := toGo(element, v.Index(i), vs); err != nil {
+					return fmt.Errorf("index %d: %w", i, err)
+				}
+			}
+			return nil
+		}
+	case reflect.Map:
+		if hash, ok := obj.(*Hash); ok {
+			if err := vs.enter(hash); err != nil {
+				return err
+			}
+			defer vs.leave(hash)
+			m := reflect.MakeMapWithSize(t, len(hash.Pairs))
+			for _, pair := range hash.Pairs {
+				key := reflect.New(t.Key()).Elem()
+				if err := toGo(pair.Key, key, vs); err != nil {
+					return fmt.Errorf("key %s: %w", pair.Key.Inspect(), err)
+				}
+				value := reflect.New(t.Elem()).Elem()
+				if err := toGo(pair.Value, value, vs); err != nil {
+					return fmt.Errorf("key %s: %w", pair.Key.Inspect(), err)
+				}
+				m.SetMapIndex(key, value)
+			}
+			v.Set(m)
+			return nil
+		}
+	case reflect.Struct:
+		if hash, ok := obj.(*Hash); ok {
+			if err := vs.enter(hash); err != nil {
+				return err
+			}
+			defer vs.leave(hash)
+			for _, field := range structFields(t) {
+				pair, ok := hash.Pairs[(&String{Value: field.name}).HashKey()]
+				if !ok {
+					continue
+				}
+				if err := toGo(pair.Value, v.FieldByIndex(field.index), vs); err != nil {
+					return fmt.Errorf("field %s: %w", field.name, err)
+				}
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot convert %s to %s", obj.Type(), t)
+}
+
+// toNatural returns the Go value ToGo stores for obj in a target of type
+// any.
+func toNatural(obj Object, vs visiting) (any, error) {
+	if obj == nil {
+		return nil, fmt.Errorf("cannot convert nil")
+	}
+	if err := vs.enter(obj); err != nil {
+		return nil, err
+	}
+	defer vs.leave(obj)
+
+	switch obj := obj.(type) {
+	case *Null:
+		return nil, nil
+	case *Boolean:
+		return obj.Value, nil
+	case *Integer:
+		return obj.Value, nil
+	case *Float:
+		return obj.Value, nil
+	case *String:
+		return obj.Value, nil
+	case *Array:
+		elements := make([]any, len(obj.Elements))
+		for i, element := range obj.Elements {
+			natural, err := toNatural(element, vs)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = natural
+		}
+		return elements, nil
+	case *Hash:
+		byString := make(map[string]any, len(obj.Pairs))
+		byAny := make(map[any]any, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			key, err := toNatural(pair.Key, vs)
+			if err != nil {
+				return nil, err
+			}
+			value, err := toNatural(pair.Value, vs)
+			if err != nil {
+				return nil, err
+			}
+			if s, ok := key.(string); ok && byString != nil {
+				byString[s] = value
+			} else {
+				byString = nil
+			}
+			byAny[key] = value
+		}
+		if byString != nil {
+			return byString, nil
+		}
+		return byAny, nil
+	}
+	return obj, nil
+}
+
+// WrapFunc wraps fn, a Go function, into a Builtin. The arguments of a call
+// are converted with ToGo to the types of the parameters of fn and its
+// result with FromGo. fn may return nothing, a value, an error, or a value
+// and an error; a non-nil error, a wrong number of arguments and arguments
+// that do not convert make the builtin return an *Error.
+func WrapFunc(fn any) (*Builtin, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return nil, fmt.Errorf("cannot wrap %T: not a function", fn)
+	}
+	t := v.Type()
+
+	numOut := t.NumOut()
+	returnsError := numOut > 0 && t.Out(numOut-1) == errorType
+	if numOut > 2 || (numOut == 2 && !returnsError) {
+		return nil, fmt.Errorf("cannot wrap %s: it must return at most a value and an error", t)
+	}
+
+	return &Builtin{Fn: func(args ...Object) Object {
+		in, err := funcArguments(t, args)
+		if err != nil {
+			return newError("%s", err)
+		}
+
+		out := v.Call(in)
+		if returnsError {
+			if err, _ := out[len(out)-1].Interface().(error); err != nil {
+				return newError("%s", err)
+			}
+			out = out[:len(out)-1]
+		}
+		if len(out) == 0 {
+			return nil
+		}
+
+		result, err := fromGo(out[0], goVisiting{})
+		if err != nil {
+			return newError("%s", err)
+		}
+		return result
+	}}, nil
+}
+
+// funcArguments converts args to the parameters of a function of type t.
+func funcArguments(t reflect.Type, args []Object) ([]reflect.Value, error) {
+	numIn := t.NumIn()
+	if t.IsVariadic() {
+		if len(args) < numIn-1 {
+			return nil, fmt.Errorf("wrong number of arguments. got=%d, want at least %d", len(args), numIn-1)
+		}
+	} else if len(args) != numIn {
+		return nil, fmt.Errorf("wrong number of arguments. got=%d, want=%d", len(args), numIn)
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var param reflect.Type
+		if t.IsVariadic() && i >= numIn-1 {
+			param = t.In(numIn - 1).Elem()
+		} else {
+			param = t.In(i)
+		}
+
+		value := reflect.New(param).Elem()
+		if err := toGo(arg, value, visiting{}); err != nil {
+			return nil, fmt.Errorf("wrong type of argument %d: %w", i+1, err)
+		}
+		in[i] = value
+	}
+	return in, nil
+}
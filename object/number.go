@@ -0,0 +1,34 @@
+package object
+
+// IntPow computes base**exp for exp >= 0 by repeated squaring. It wraps
+// around on overflow like the other integer operators.
+func IntPow(base, exp int64) int64 {
+	result := int64(1)
+	for exp > 0 {
+		if exp&1 == 1 {
+			result *= base
+		}
+		base *= base
+		exp >>= 1
+	}
+	return result
+}
+
+// IsNumber reports whether obj is an integer or a float.
+func IsNumber(obj Object) bool {
+	return obj.Type() == INTEGER_OBJ || obj.Type() == FLOAT_OBJ
+}
+
+// ToFloat returns the value of an integer or a float as a float64, and 0 for
+// anything else. Both engines use it to mix integers and floats in
+// arithmetic.
+func ToFloat(obj Object) float64 {
+	switch obj := obj.(type) {
+	case *Integer:
+		return float64(obj.Value)
+	case *Float:
+		return obj.Value
+	default:
+		return 0
+	}
+}
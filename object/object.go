@@ -2,10 +2,13 @@ package object
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"hash/fnv"
+	"math"
 	"monkey/ast"
 	"monkey/code"
+	"strconv"
 	"strings"
 )
 
@@ -15,6 +18,7 @@ type BuiltinFunction func(args ...Object) Object
 
 const (
 	INTEGER_OBJ           = "INTEGER"
+	FLOAT_OBJ             = "FLOAT"
 	BOOLEAN_OBJ           = "BOOLEAN"
 	NULL_OBJ              = "NULL"
 	RETURN_VALUE_OBJ      = "RETURN_VALUE"
@@ -26,6 +30,11 @@ const (
 	HASH_OBJ              = "HASH"
 	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION_OBJ"
 	CLOSURE_OBJ           = "CLOSURE_OBJ"
+	CELL_OBJ              = "CELL"
+	ITERATOR_OBJ          = "ITERATOR"
+	BREAK_OBJ             = "BREAK"
+	CONTINUE_OBJ          = "CONTINUE"
+	TAIL_CALL_OBJ         = "TAIL_CALL"
 )
 
 type Object interface {
@@ -37,6 +46,13 @@ type CompiledFunction struct {
 	Instructions  code.Instructions
 	NumLocals     int
 	NumParameters int
+
+	// Handlers lists the exception handlers of the function
+	Handlers code.HandlerTable
+
+	// debug info
+	Name  string
+	Lines code.LineTable
 }
 
 func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
@@ -50,6 +66,22 @@ type Integer struct{ Value int64 }
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 
+type Float struct{ Value float64 }
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+func (f *Float) Inspect() string {
+	format := byte('f')
+	if abs := math.Abs(f.Value); abs >= 1e21 || (abs != 0 && abs < 1e-6) {
+		format = 'g'
+	}
+	s := strconv.FormatFloat(f.Value, format, -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		// keep floats with integral values distinguishable from integers
+		s += ".0"
+	}
+	return s
+}
+
 type Boolean struct{ Value bool }
 
 func (b *Boolean) Type() ObjectType { return BOOLEAN_OBJ }
@@ -60,12 +92,57 @@ type Null struct{}
 func (n *Null) Type() ObjectType { return NULL_OBJ }
 func (n *Null) Inspect() string  { return "null" }
 
+// TRUE, FALSE and NULL are the only boolean and null values. Both engines
+// compare them by identity, so every true, false and null handed to a
+// program has to be one of them.
+var (
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+	NULL  = &Null{}
+)
+
 type ReturnValue struct{ Value Object }
 
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
-type Error struct{ Message string }
+// Break and Continue signal a break or continue statement to the enclosing
+// loop in the evaluator, like ReturnValue does for return.
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+func (b *Break) Inspect() string  { return "break" }
+
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+func (c *Continue) Inspect() string  { return "continue" }
+
+// TailCall is a call in tail position that the evaluator has not made yet.
+// It is made once the calling function has returned, so that recursion
+// through tail calls does not grow the Go stack.
+type TailCall struct {
+	Function  Object
+	Arguments []Object
+}
+
+func (tc *TailCall) Type() ObjectType { return TAIL_CALL_OBJ }
+func (tc *TailCall) Inspect() string  { return "tail call" }
+
+// ErrTimeout is what both engines stop with once the context they run in is
+// done: the VM returns it from Run, and the evaluator sets it as the Err of
+// the error object it returns. vm.ErrTimeout is the same value.
+var ErrTimeout = errors.New("execution timed out")
+
+// Error is a runtime error in the evaluator. Value is set for errors
+// raised by a throw statement and holds the value thrown, which is what a
+// catch clause receives instead of the message. Err is set for errors a host
+// may want to match with errors.Is, such as ErrTimeout.
+type Error struct {
+	Message string
+	Value   Object
+	Err     error
+}
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 func (e *Error) Inspect() string  { return "ERROR:" + e.Message }
@@ -148,6 +225,13 @@ func (i *Integer) HashKey() HashKey {
 	}
 }
 
+func (f *Float) HashKey() HashKey {
+	return HashKey{
+		Type:  f.Type(),
+		Value: math.Float64bits(f.Value),
+	}
+}
+
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -194,3 +278,10 @@ type Closure struct {
 
 func (c *Closure) Type() ObjectType { return CLOSURE_OBJ }
 func (c *Closure) Inspect() string  { return fmt.Sprintf("Closure[%p]", c) }
+
+// Cell holds a variable shared between a function and the closures that
+// capture it. It only appears in VM locals and free variables.
+type Cell struct{ Value Object }
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string  { return c.Value.Inspect() }
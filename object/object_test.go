@@ -1,6 +1,50 @@
 package object
 
-import "testing"
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{2, "2.0"},
+		{0.5, "0.5"},
+		{-3, "-3.0"},
+		{123456789, "123456789.0"},
+		{1e21, "1e+21"},
+	}
+
+	for _, tt := range tests {
+		f := &Float{Value: tt.value}
+		if f.Inspect() != tt.expected {
+			t.Errorf("wrong Inspect for %v. want=%q, got=%q",
+				tt.value, tt.expected, f.Inspect())
+		}
+	}
+}
+
+func TestIntPow(t *testing.T) {
+	tests := []struct {
+		base, exp, expected int64
+	}{
+		{2, 0, 1},
+		{2, 10, 1024},
+		{-3, 3, -27},
+		{0, 0, 1},
+		{2, 64, 0},
+	}
+
+	for _, tt := range tests {
+		if got := IntPow(tt.base, tt.exp); got != tt.expected {
+			t.Errorf("IntPow(%d, %d): want=%d, got=%d",
+				tt.base, tt.exp, tt.expected, got)
+		}
+	}
+}
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -20,3 +64,410 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have same hash keys")
 	}
 }
+
+func TestRegistry(t *testing.T) {
+	r := NewDefaultRegistry()
+	for i, def := range Builtins {
+		builtin, ok := r.Get(i)
+		if !ok || builtin != def.Builtin {
+			t.Errorf("builtin %d is not %s", i, def.Name)
+		}
+	}
+
+	double := func(args ...Object) Object {
+		return &Integer{Value: args[0].(*Integer).Value * 2}
+	}
+	if err := r.Register("double", 1, double, "doubles an integer"); err != nil {
+		t.Fatalf("Register failed: %s", err)
+	}
+	if err := r.Register("double", 1, double, ""); err == nil || err.Error() != `builtin "double" is already registered` {
+		t.Errorf("wrong error for a duplicate. got=%v", err)
+	}
+
+	defs := r.Definitions()
+	if r.Len() != len(Builtins)+1 || len(defs) != r.Len() {
+		t.Fatalf("wrong number of builtins. got=%d", r.Len())
+	}
+	last := defs[len(defs)-1]
+	if last.Name != "double" || last.Arity != 1 || last.Doc != "doubles an integer" {
+		t.Errorf("wrong definition. got=%+v", last)
+	}
+
+	builtin, ok := r.Lookup("double")
+	if !ok || builtin != last.Builtin {
+		t.Fatalf("Lookup did not find double")
+	}
+	if result := builtin.Fn(&Integer{Value: 21}); result.Inspect() != "42" {
+		t.Errorf("wrong result. got=%s", result.Inspect())
+	}
+	if result := builtin.Fn(); result.Inspect() != "ERROR:wrong number of arguments. got=0, want=1" {
+		t.Errorf("wrong result for a wrong number of arguments. got=%s", result.Inspect())
+	}
+
+	if _, ok := r.Lookup("triple"); ok {
+		t.Errorf("Lookup found an unregistered builtin")
+	}
+	if _, ok := r.Get(r.Len()); ok {
+		t.Errorf("Get found a builtin out of range")
+	}
+	if _, ok := NewDefaultRegistry().Lookup("double"); ok {
+		t.Errorf("registering changed the default registry")
+	}
+
+	full := NewRegistry()
+	for i := 0; i < MaxBuiltins; i++ {
+		if err := full.Register(fmt.Sprintf("f%d", i), -1, double, ""); err != nil {
+			t.Fatalf("Register failed: %s", err)
+		}
+	}
+	if err := full.Register("overflow", -1, double, ""); err == nil || err.Error() != "too many builtins: the registry is limited to 256" {
+		t.Errorf("wrong error for a full registry. got=%v", err)
+	}
+}
+
+type marshalAddress struct {
+	City string `monkey:"city"`
+	Zip  *int   `monkey:"zip"`
+}
+
+type marshalPerson struct {
+	Name     string           `monkey:"name"`
+	Age      uint8            `monkey:"age"`
+	Score    float64          `monkey:"score"`
+	Admin    bool             `monkey:"admin"`
+	Tags     []string         `monkey:"tags"`
+	Address  *marshalAddress  `monkey:"address"`
+	Extra    map[string]int64 `monkey:"extra"`
+	Untagged int
+	Secret   string `monkey:"-"`
+	internal string
+}
+
+func hashValue(t *testing.T, obj Object, key string) Object {
+	t.Helper()
+	hash, ok := obj.(*Hash)
+	if !ok {
+		t.Fatalf("object is not Hash. got=%T (%+v)", obj, obj)
+	}
+	pair, ok := hash.Pairs[(&String{Value: key}).HashKey()]
+	if !ok {
+		return nil
+	}
+	return pair.Value
+}
+
+func TestFromGo(t *testing.T) {
+	zip := 12345
+	person := marshalPerson{
+		Name:     "Ann",
+		Age:      42,
+		Score:    1.5,
+		Admin:    true,
+		Tags:     []string{"a", "b"},
+		Address:  &marshalAddress{City: "Oslo", Zip: &zip},
+		Extra:    map[string]int64{"x": 1},
+		Untagged: 7,
+		Secret:   "hidden",
+		internal: "hidden",
+	}
+
+	obj, err := FromGo(person)
+	if err != nil {
+		t.Fatalf("FromGo failed: %s", err)
+	}
+	if hash := obj.(*Hash); len(hash.Pairs) != 8 {
+		t.Errorf("wrong number of pairs. got=%d", len(hash.Pairs))
+	}
+
+	tests := []struct {
+		value    Object
+		expected string
+	}{
+		{hashValue(t, obj, "name"), "Ann"},
+		{hashValue(t, obj, "age"), "42"},
+		{hashValue(t, obj, "score"), "1.5"},
+		{hashValue(t, obj, "admin"), "true"},
+		{hashValue(t, obj, "tags"), "[a, b]"},
+		{hashValue(t, hashValue(t, obj, "address"), "city"), "Oslo"},
+		{hashValue(t, hashValue(t, obj, "address"), "zip"), "12345"},
+		{hashValue(t, hashValue(t, obj, "extra"), "x"), "1"},
+		{hashValue(t, obj, "Untagged"), "7"},
+	}
+	for i, tt := range tests {
+		if tt.value == nil || tt.value.Inspect() != tt.expected {
+			t.Errorf("tests[%d]: wrong value. want=%q, got=%v", i, tt.expected, tt.value)
+		}
+	}
+	for _, key := range []string{"Secret", "-", "internal"} {
+		if value := hashValue(t, obj, key); value != nil {
+			t.Errorf("field %q should be left out. got=%v", key, value)
+		}
+	}
+
+	values := []struct {
+		input    any
+		expected string
+	}{
+		{nil, "null"},
+		{(*marshalAddress)(nil), "null"},
+		{[]int(nil), "null"},
+		{[2]bool{true, false}, "[true, false]"},
+		{[]any{1, "two", 3.0, nil}, "[1, two, 3.0, null]"},
+		{map[int]string{1: "one"}, "{1: one}"},
+		{&String{Value: "as is"}, "as is"},
+		{[]Object{&Integer{Value: 1}, nil}, "[1, null]"},
+		{int8(-3), "-3"},
+		{float32(0.5), "0.5"},
+	}
+	for _, tt := range values {
+		obj, err := FromGo(tt.input)
+		if err != nil {
+			t.Errorf("FromGo(%#v) failed: %s", tt.input, err)
+			continue
+		}
+		if obj.Inspect() != tt.expected {
+			t.Errorf("FromGo(%#v): want=%q, got=%q", tt.input, tt.expected, obj.Inspect())
+		}
+	}
+
+	singletons := []struct {
+		input    any
+		expected Object
+	}{
+		{true, TRUE},
+		{false, FALSE},
+		{nil, NULL},
+		{(*int)(nil), NULL},
+		{&Boolean{Value: true}, TRUE},
+		{&Null{}, NULL},
+	}
+	for _, tt := range singletons {
+		obj, err := FromGo(tt.input)
+		if err != nil || obj != tt.expected {
+			t.Errorf("FromGo(%#v): want the %s singleton, got=%p (%v)", tt.input, tt.expected.Inspect(), obj, err)
+		}
+	}
+
+	errorInputs := []struct {
+		input    any
+		expected string
+	}{
+		{make(chan int), "cannot convert values of type chan int"},
+		{uint64(1 << 63), "cannot convert 9223372036854775808 to INTEGER: out of range"},
+		{map[[1]int]int{{1}: 1}, "unusable as hash key: ARRAY"},
+		{struct{ C chan int }{}, "field C: cannot convert values of type chan int"},
+	}
+	for _, tt := range errorInputs {
+		_, err := FromGo(tt.input)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("FromGo(%#v): wrong error. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+
+	type node struct{ Next *node }
+	loop := &node{}
+	loop.Next = loop
+	slice := []any{1, nil}
+	slice[1] = slice
+	m := map[string]any{}
+	m["self"] = m
+	cycles := []struct {
+		input    any
+		expected string
+	}{
+		{loop, "field Next: cannot convert *object.node: it refers to itself"},
+		{slice, "cannot convert []interface {}: it refers to itself"},
+		{m, "cannot convert map[string]interface {}: it refers to itself"},
+	}
+	for _, tt := range cycles {
+		_, err := FromGo(tt.input)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("FromGo(%T): wrong error. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+
+	shared := &node{}
+	pair := []*node{shared, shared}
+	if obj, err := FromGo(pair); err != nil {
+		t.Errorf("FromGo of a shared pointer failed: %s", err)
+	} else if obj.Inspect() != "[{Next: null}, {Next: null}]" {
+		t.Errorf("FromGo of a shared pointer: got=%s", obj.Inspect())
+	}
+}
+
+func TestToGo(t *testing.T) {
+	zip := 12345
+	original := marshalPerson{
+		Name:    "Ann",
+		Age:     42,
+		Score:   1.5,
+		Admin:   true,
+		Tags:    []string{"a", "b"},
+		Address: &marshalAddress{City: "Oslo", Zip: &zip},
+		Extra:   map[string]int64{"x": 1},
+		Secret:  "hidden",
+	}
+	obj, err := FromGo(original)
+	if err != nil {
+		t.Fatalf("FromGo failed: %s", err)
+	}
+
+	var person marshalPerson
+	if err := ToGo(obj, &person); err != nil {
+		t.Fatalf("ToGo failed: %s", err)
+	}
+	if person.Name != "Ann" || person.Age != 42 || person.Score != 1.5 || !person.Admin ||
+		len(person.Tags) != 2 || person.Tags[1] != "b" || person.Extra["x"] != 1 || person.Secret != "" {
+		t.Errorf("wrong struct. got=%+v", person)
+	}
+	if person.Address == nil || person.Address.City != "Oslo" || person.Address.Zip == nil || *person.Address.Zip != 12345 {
+		t.Errorf("wrong address. got=%+v", person.Address)
+	}
+
+	var natural any
+	if err := ToGo(obj, &natural); err != nil {
+		t.Fatalf("ToGo failed: %s", err)
+	}
+	m, ok := natural.(map[string]any)
+	if !ok || m["age"] != int64(42) || m["tags"].([]any)[0] != "a" || m["address"].(map[string]any)["city"] != "Oslo" {
+		t.Errorf("wrong natural value. got=%#v", natural)
+	}
+
+	var mixed any
+	hash := &Hash{Pairs: map[HashKey]HashPair{}}
+	one, yes := &Integer{Value: 1}, &Boolean{Value: true}
+	hash.Pairs[one.HashKey()] = HashPair{Key: one, Value: &Null{}}
+	hash.Pairs[yes.HashKey()] = HashPair{Key: yes, Value: &Float{Value: 2}}
+	if err := ToGo(hash, &mixed); err != nil {
+		t.Fatalf("ToGo failed: %s", err)
+	}
+	if m, ok := mixed.(map[any]any); !ok || m[int64(1)] != nil || m[true] != 2.0 {
+		t.Errorf("wrong natural value. got=%#v", mixed)
+	}
+
+	var f float32
+	if err := ToGo(&Integer{Value: 3}, &f); err != nil || f != 3 {
+		t.Errorf("integer to float32: got=%v (%v)", f, err)
+	}
+	var o Object
+	if err := ToGo(one, &o); err != nil || o != one {
+		t.Errorf("object to Object: got=%v (%v)", o, err)
+	}
+	s := []int{1}
+	if err := ToGo(&Null{}, &s); err != nil || s != nil {
+		t.Errorf("null to slice: got=%v (%v)", s, err)
+	}
+
+	errorTests := []struct {
+		obj      Object
+		target   any
+		expected string
+	}{
+		{one, person, "ToGo needs a non-nil pointer, got object.marshalPerson"},
+		{&String{Value: "1"}, new(int), "cannot convert STRING to int"},
+		{&Integer{Value: 300}, new(uint8), "cannot convert 300 to uint8: out of range"},
+		{&Integer{Value: -1}, new(uint), "cannot convert -1 to uint: out of range"},
+		{&Float{Value: 1.5}, new(int), "cannot convert FLOAT to int"},
+		{&Array{Elements: []Object{one, yes}}, new([]int), "index 1: cannot convert BOOLEAN to int"},
+		{&Array{Elements: []Object{one}}, new([2]int), "cannot convert ARRAY of 1 elements to [2]int"},
+		{obj, new(map[string]string), ""},
+	}
+	for _, tt := range errorTests {
+		err := ToGo(tt.obj, tt.target)
+		if err == nil || (tt.expected != "" && err.Error() != tt.expected) {
+			t.Errorf("ToGo(%s, %T): wrong error. want=%q, got=%v", tt.obj.Inspect(), tt.target, tt.expected, err)
+		}
+	}
+
+	var n int
+	if err := ToGo(nil, &n); err == nil || err.Error() != "cannot convert nil to int" {
+		t.Errorf("ToGo(nil, *int): wrong error. got=%v", err)
+	}
+
+	shared := &Array{Elements: []Object{one}}
+	var nested [][]int
+	if err := ToGo(&Array{Elements: []Object{shared, shared}}, &nested); err != nil || len(nested) != 2 || nested[1][0] != 1 {
+		t.Errorf("shared array: got=%v (%v)", nested, err)
+	}
+
+	cyclic := &Array{Elements: []Object{one}}
+	cyclic.Elements = append(cyclic.Elements, cyclic)
+	cyclicHash := &Hash{Pairs: map[HashKey]HashPair{}}
+	cyclicHash.Pairs[one.HashKey()] = HashPair{Key: one, Value: cyclicHash}
+	cycleTests := []struct {
+		obj    Object
+		target any
+	}{
+		{cyclic, new(any)},
+		{cyclic, new([]any)},
+		{cyclicHash, new(any)},
+		{cyclicHash, new(map[int]any)},
+	}
+	for _, tt := range cycleTests {
+		err := ToGo(tt.obj, tt.target)
+		if err == nil || !strings.HasSuffix(err.Error(), "contains itself") {
+			t.Errorf("ToGo(cyclic %s, %T): wrong error. got=%v", tt.obj.Type(), tt.target, err)
+		}
+	}
+}
+
+func TestWrapFunc(t *testing.T) {
+	tests := []struct {
+		fn       any
+		args     []Object
+		expected string
+	}{
+		{func(a int, b float64) float64 { return float64(a) + b },
+			[]Object{&Integer{Value: 1}, &Integer{Value: 2}}, "3.0"},
+		{func(s string) (int, error) { return len(s), nil },
+			[]Object{&String{Value: "four"}}, "4"},
+		{func(s string) (int, error) { return 0, fmt.Errorf("bad %s", s) },
+			[]Object{&String{Value: "input"}}, "ERROR:bad input"},
+		{func() error { return nil }, nil, "null"},
+		{func(sep string, parts ...string) string { return fmt.Sprint(len(parts), sep) },
+			[]Object{&String{Value: "-"}, &String{Value: "a"}, &String{Value: "b"}}, "2-"},
+		{func(sep string, parts ...string) string { return fmt.Sprint(len(parts), sep) },
+			nil, "ERROR:wrong number of arguments. got=0, want at least 1"},
+		{func(a int) int { return a },
+			nil, "ERROR:wrong number of arguments. got=0, want=1"},
+		{func(a int) int { return a },
+			[]Object{&String{Value: "x"}}, "ERROR:wrong type of argument 1: cannot convert STRING to int"},
+		{func(p marshalAddress) []string { return []string{p.City} },
+			[]Object{&Hash{Pairs: map[HashKey]HashPair{
+				(&String{Value: "city"}).HashKey(): {Key: &String{Value: "city"}, Value: &String{Value: "Rome"}},
+			}}}, "[Rome]"},
+		{func() chan int { return make(chan int) }, nil, "ERROR:cannot convert values of type chan int"},
+	}
+
+	for i, tt := range tests {
+		builtin, err := WrapFunc(tt.fn)
+		if err != nil {
+			t.Fatalf("tests[%d]: WrapFunc failed: %s", i, err)
+		}
+		result := builtin.Fn(tt.args...)
+		if result == nil {
+			result = &Null{}
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("tests[%d]: want=%q, got=%q", i, tt.expected, result.Inspect())
+		}
+	}
+
+	invalid := []any{42, (func())(nil), func() (int, int) { return 0, 0 }}
+	for _, fn := range invalid {
+		if _, err := WrapFunc(fn); err == nil {
+			t.Errorf("WrapFunc(%T) should fail", fn)
+		}
+	}
+
+	r := NewRegistry()
+	if err := r.RegisterFunc("join", func(parts ...string) int { return len(parts) }, ""); err != nil {
+		t.Fatalf("RegisterFunc failed: %s", err)
+	}
+	if err := r.RegisterFunc("add", func(a, b int) int { return a + b }, ""); err != nil {
+		t.Fatalf("RegisterFunc failed: %s", err)
+	}
+	if defs := r.Definitions(); defs[0].Arity != -1 || defs[1].Arity != 2 {
+		t.Errorf("wrong arities. got=%d, %d", defs[0].Arity, defs[1].Arity)
+	}
+}
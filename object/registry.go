@@ -0,0 +1,118 @@
+package object
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// MaxBuiltins is the number of builtins the 1-byte operand of OpGetBuiltin
+// can address.
+const MaxBuiltins = 1 << 8
+
+// BuiltinDef describes a builtin function of a Registry.
+type BuiltinDef struct {
+	Name string
+	// Arity is the number of arguments the function takes, -1 when it
+	// takes any number
+	Arity   int
+	Doc     string
+	Builtin *Builtin
+}
+
+// Registry holds the builtin functions a program can call. Builtins are
+// compiled to their index in the registry, so the compiler and the VM
+// running its bytecode have to be given the same registry.
+type Registry struct {
+	defs  []BuiltinDef
+	index map[string]int
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{index: map[string]int{}}
+}
+
+// NewDefaultRegistry returns a registry holding the standard Builtins.
+func NewDefaultRegistry() *Registry {
+	r := NewRegistry()
+	for _, def := range Builtins {
+		r.add(def)
+	}
+	return r
+}
+
+// Register adds the builtin name implemented by fn. When arity is not -1,
+// calls with another number of arguments return an error without calling
+// fn.
+func (r *Registry) Register(name string, arity int, fn BuiltinFunction, doc string) error {
+	checked := fn
+	if arity >= 0 {
+		checked = func(args ...Object) Object {
+			if len(args) != arity {
+				return newError("wrong number of arguments. got=%d, want=%d",
+					len(args), arity)
+			}
+			return fn(args...)
+		}
+	}
+	return r.define(BuiltinDef{Name: name, Arity: arity, Doc: doc, Builtin: &Builtin{Fn: checked}})
+}
+
+// RegisterFunc adds the builtin name implemented by fn, any Go function
+// WrapFunc accepts.
+func (r *Registry) RegisterFunc(name string, fn any, doc string) error {
+	builtin, err := WrapFunc(fn)
+	if err != nil {
+		return err
+	}
+	t := reflect.TypeOf(fn)
+	arity := t.NumIn()
+	if t.IsVariadic() {
+		arity = -1
+	}
+	return r.define(BuiltinDef{Name: name, Arity: arity, Doc: doc, Builtin: builtin})
+}
+
+func (r *Registry) define(def BuiltinDef) error {
+	if _, ok := r.index[def.Name]; ok {
+		return fmt.Errorf("builtin %q is already registered", def.Name)
+	}
+	if len(r.defs) >= MaxBuiltins {
+		return fmt.Errorf("too many builtins: the registry is limited to %d", MaxBuiltins)
+	}
+	r.add(def)
+	return nil
+}
+
+func (r *Registry) add(def BuiltinDef) {
+	r.index[def.Name] = len(r.defs)
+	r.defs = append(r.defs, def)
+}
+
+// Len returns the number of builtins in the registry.
+func (r *Registry) Len() int {
+	return len(r.defs)
+}
+
+// Definitions returns the builtins of the registry in the order of their
+// indexes.
+func (r *Registry) Definitions() []BuiltinDef {
+	return append([]BuiltinDef(nil), r.defs...)
+}
+
+// Get returns the builtin at index.
+func (r *Registry) Get(index int) (*Builtin, bool) {
+	if index < 0 || index >= len(r.defs) {
+		return nil, false
+	}
+	return r.defs[index].Builtin, true
+}
+
+// Lookup returns the builtin called name.
+func (r *Registry) Lookup(name string) (*Builtin, bool) {
+	index, ok := r.index[name]
+	if !ok {
+		return nil, false
+	}
+	return r.defs[index].Builtin, true
+}
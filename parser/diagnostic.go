@@ -0,0 +1,37 @@
+package parser
+
+import (
+	"fmt"
+	"monkey/token"
+)
+
+// Severity is how serious a diagnostic is. The parser only reports errors
+// so far.
+type Severity int
+
+const (
+	SeverityError Severity = iota
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// Diagnostic is a single problem found while parsing. Expected and Got are
+// set when the problem is an unexpected token and are empty otherwise.
+type Diagnostic struct {
+	Severity Severity
+	Pos      token.Position
+	Message  string
+	Expected token.TokenType
+	Got      token.TokenType
+}
+
+func (d Diagnostic) Error() string {
+	return fmt.Sprintf("%s: %s", d.Pos, d.Message)
+}
@@ -16,32 +16,66 @@ type (
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN
+	OR
+	AND
 	EQUALS
 	LESSGREATER
+	BIT_OR
+	BIT_XOR
+	BIT_AND
+	SHIFT
 	SUM
 	PRODUCT
 	PREFIX
+	POWER
 	CALL
 	INDEX
 )
 
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+	token.OR:              OR,
+	token.AND:             AND,
+	token.EQ:              EQUALS,
+	token.NOT_EQ:          EQUALS,
+	token.LT:              LESSGREATER,
+	token.GT:              LESSGREATER,
+	token.LT_EQ:           LESSGREATER,
+	token.GT_EQ:           LESSGREATER,
+	token.BIT_OR:          BIT_OR,
+	token.BIT_XOR:         BIT_XOR,
+	token.BIT_AND:         BIT_AND,
+	token.SHL:             SHIFT,
+	token.SHR:             SHIFT,
+	token.PLUS:            SUM,
+	token.MINUS:           SUM,
+	token.SLASH:           PRODUCT,
+	token.ASTERISK:        PRODUCT,
+	token.PERCENT:         PRODUCT,
+	token.POWER:           POWER,
+	token.LPAREN:          CALL,
+	token.LBRACKET:        INDEX,
 }
 
 type Parser struct {
 	l *lexer.Lexer
 
-	errors []string
+	diagnostics []Diagnostic
+	// panicking is set after a syntax error and cleared once the parser has
+	// resynchronized at a statement boundary. Errors reported in between are
+	// dropped since they are almost always caused by the first one.
+	panicking bool
+	// loopDepth counts the loops enclosing the current statement within the
+	// current function body, so break and continue can be checked.
+	loopDepth int
+	// inFinally is set in a finally block, which break and continue can't
+	// leave; loopDepth then only counts the loops inside the block.
+	inFinally bool
 
 	currToken token.Token
 	peekToken token.Token
@@ -52,23 +86,28 @@ type Parser struct {
 
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
-		l:      l,
-		errors: make([]string, 0),
+		l:           l,
+		diagnostics: make([]Diagnostic, 0),
 	}
 
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
+	p.registerPrefix(token.BIT_NOT, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.LPAREN, p.parseGroupExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.TEMPLATE_HEAD, p.parseInterpolatedString)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
+	p.registerPrefix(token.ILLEGAL, p.parseIllegal)
+	p.registerPrefix(token.ERROR, p.parseLexError)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
@@ -79,8 +118,24 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.POWER, p.parseInfixExpression)
+	p.registerInfix(token.BIT_AND, p.parseInfixExpression)
+	p.registerInfix(token.BIT_OR, p.parseInfixExpression)
+	p.registerInfix(token.BIT_XOR, p.parseInfixExpression)
+	p.registerInfix(token.SHL, p.parseInfixExpression)
+	p.registerInfix(token.SHR, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)
 
 	p.nextToken()
 	p.nextToken()
@@ -101,12 +156,42 @@ func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
 }
 
 func (p *Parser) Errors() []string {
-	return p.errors
+	errors := make([]string, 0, len(p.diagnostics))
+	for _, d := range p.diagnostics {
+		errors = append(errors, d.Error())
+	}
+	return errors
+}
+
+func (p *Parser) Diagnostics() []Diagnostic {
+	return p.diagnostics
+}
+
+func (p *Parser) addDiagnostic(d Diagnostic) {
+	if p.panicking {
+		return
+	}
+	p.panicking = true
+	p.diagnostics = append(p.diagnostics, d)
+}
+
+func (p *Parser) errorAt(pos token.Position, format string, a ...any) {
+	p.addDiagnostic(Diagnostic{
+		Severity: SeverityError,
+		Pos:      pos,
+		Message:  fmt.Sprintf(format, a...),
+	})
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be '%s', get '%s'", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.addDiagnostic(Diagnostic{
+		Severity: SeverityError,
+		Pos:      p.peekToken.Pos,
+		Message: fmt.Sprintf("expected next token to be '%s', get '%s'",
+			t, p.peekToken.Type),
+		Expected: t,
+		Got:      p.peekToken.Type,
+	})
 }
 
 func (p *Parser) nextToken() {
@@ -114,20 +199,58 @@ func (p *Parser) nextToken() {
 	p.peekToken = p.l.NextToken()
 }
 
+// ParseProgram parses the whole input. Statements containing syntax errors
+// are left out of the returned program, so it is still usable when Errors
+// is not empty.
 func (p *Parser) ParseProgram() *ast.Program {
-	program := &ast.Program{
-		Statements: make([]ast.Statement, 0),
-	}
+	program := &ast.Program{}
+	program.Statements = p.parseStatementList(token.EOF)
 
-	for !p.currTokenIs(token.EOF) {
+	return program
+}
+
+func (p *Parser) parseStatementList(end token.TokenType) []ast.Statement {
+	stmts := make([]ast.Statement, 0)
+	// a block nested in an expression that already failed is recovered
+	// from by the enclosing statement
+	canRecover := !p.panicking
+
+	for !p.currTokenIs(end) && !p.currTokenIs(token.EOF) {
+		start := p.currToken
 		stmt := p.parseStatement()
+		if canRecover && p.panicking {
+			p.synchronize(start)
+			continue
+		}
 		if stmt != nil {
-			program.Statements = append(program.Statements, stmt)
+			stmts = append(stmts, stmt)
 		}
 		p.nextToken()
 	}
 
-	return program
+	return stmts
+}
+
+// synchronize skips tokens until the start of the next statement: the token
+// after a ';', a statement keyword, a '}' closing the enclosing block or EOF.
+func (p *Parser) synchronize(start token.Token) {
+	if p.currToken.Pos == start.Pos {
+		p.nextToken()
+	}
+
+	for !p.currTokenIs(token.EOF) {
+		switch p.currToken.Type {
+		case token.SEMICOLON:
+			p.nextToken()
+			p.panicking = false
+			return
+		case token.LET, token.RETURN, token.WHILE, token.FOR, token.TRY, token.THROW, token.RBRACE:
+			p.panicking = false
+			return
+		}
+		p.nextToken()
+	}
+	p.panicking = false
 }
 
 func (p *Parser) parseStatement() ast.Statement {
@@ -136,6 +259,18 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.WHILE:
+		return p.parseWhileStatement()
+	case token.FOR:
+		return p.parseForStatement()
+	case token.BREAK:
+		return p.parseBreakStatement()
+	case token.CONTINUE:
+		return p.parseContinueStatement()
+	case token.TRY:
+		return p.parseTryStatement()
+	case token.THROW:
+		return p.parseThrowStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -181,6 +316,180 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+func (p *Parser) parseWhileStatement() ast.Statement {
+	stmt := &ast.WhileStatement{Token: p.currToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseForStatement() ast.Statement {
+	stmt := &ast.ForStatement{Token: p.currToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Variable = &ast.Identifier{
+		Token: p.currToken,
+		Value: p.currToken.Literal,
+	}
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loopDepth++
+	defer func() { p.loopDepth-- }()
+
+	return p.parseBlockStatement()
+}
+
+func (p *Parser) parseBreakStatement() ast.Statement {
+	stmt := &ast.BreakStatement{Token: p.currToken}
+	if p.loopDepth == 0 {
+		p.loopError("break")
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseContinueStatement() ast.Statement {
+	stmt := &ast.ContinueStatement{Token: p.currToken}
+	if p.loopDepth == 0 {
+		p.loopError("continue")
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+// loopError reports a break or continue statement that has no loop to
+// apply to.
+func (p *Parser) loopError(keyword string) {
+	if p.inFinally {
+		p.errorAt(p.currToken.Pos, "%s out of finally block", keyword)
+		return
+	}
+	p.errorAt(p.currToken.Pos, "%s outside of loop", keyword)
+}
+
+func (p *Parser) parseTryStatement() ast.Statement {
+	stmt := &ast.TryStatement{Token: p.currToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+		if p.peekTokenIs(token.LPAREN) {
+			p.nextToken()
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			stmt.Param = &ast.Identifier{
+				Token: p.currToken,
+				Value: p.currToken.Literal,
+			}
+			if !p.expectPeek(token.RPAREN) {
+				return nil
+			}
+		}
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		stmt.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		stmt.Finally = p.parseFinallyBlock()
+	}
+
+	if stmt.Catch == nil && stmt.Finally == nil {
+		p.errorAt(stmt.Token.Pos, "try without catch or finally")
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+// parseFinallyBlock parses the block of a finally clause. It may be run
+// while a return value or an exception is pending, so break and continue
+// can only apply to loops inside it.
+func (p *Parser) parseFinallyBlock() *ast.BlockStatement {
+	outerLoopDepth, outerInFinally := p.loopDepth, p.inFinally
+	p.loopDepth, p.inFinally = 0, true
+	defer func() { p.loopDepth, p.inFinally = outerLoopDepth, outerInFinally }()
+
+	return p.parseBlockStatement()
+}
+
+func (p *Parser) parseThrowStatement() ast.Statement {
+	stmt := &ast.ThrowStatement{Token: p.currToken}
+	p.nextToken()
+
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
 func (p *Parser) expectPeek(t token.TokenType) bool {
 	if p.peekTokenIs(t) {
 		p.nextToken()
@@ -229,8 +538,25 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 }
 
 func (p *Parser) noPrefixParseFnError(tokenType token.TokenType) {
-	p.errors = append(p.errors,
-		fmt.Sprintf("no prefix parse function for %s found", tokenType))
+	p.addDiagnostic(Diagnostic{
+		Severity: SeverityError,
+		Pos:      p.currToken.Pos,
+		Message:  fmt.Sprintf("no prefix parse function for %s found", tokenType),
+		Got:      tokenType,
+	})
+}
+
+// parseIllegal reports a stray character the lexer gave an ILLEGAL token.
+func (p *Parser) parseIllegal() ast.Expression {
+	p.errorAt(p.currToken.Pos, "illegal character %q", p.currToken.Literal)
+	return nil
+}
+
+// parseLexError reports a malformed token, such as an unterminated string,
+// with the message the lexer gave it.
+func (p *Parser) parseLexError() ast.Expression {
+	p.errorAt(p.currToken.Pos, "%s", p.currToken.Literal)
+	return nil
 }
 
 func (p *Parser) parseIntegerLiteral() ast.Expression {
@@ -238,8 +564,21 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 
 	val, err := strconv.ParseInt(p.currToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.currToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.errorAt(p.currToken.Pos, "could not parse %q as integer",
+			p.currToken.Literal)
+		return nil
+	}
+	lit.Value = val
+	return lit
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.currToken}
+
+	val, err := strconv.ParseFloat(p.currToken.Literal, 64)
+	if err != nil {
+		p.errorAt(p.currToken.Pos, "could not parse %q as float",
+			p.currToken.Literal)
 		return nil
 	}
 	lit.Value = val
@@ -278,12 +617,37 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	}
 
 	precedence := p.currPrecedence()
+	if precedence == POWER {
+		// ** is right-associative: a ** b ** c is a ** (b ** c)
+		precedence--
+	}
 	p.nextToken()
 	exp.Right = p.parseExpression(precedence)
 
 	return exp
 }
 
+func (p *Parser) parseAssignExpression(target ast.Expression) ast.Expression {
+	exp := &ast.AssignExpression{
+		Token:    p.currToken,
+		Target:   target,
+		Operator: p.currToken.Literal,
+	}
+
+	switch target.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	default:
+		p.errorAt(p.currToken.Pos, "cannot assign to %s", target)
+		return nil
+	}
+
+	p.nextToken()
+	// assignment is right-associative: a = b = c is a = (b = c)
+	exp.Value = p.parseExpression(ASSIGN - 1)
+
+	return exp
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{Token: p.currToken, Value: p.currTokenIs(token.TRUE)}
 }
@@ -331,16 +695,19 @@ func (p *Parser) parseIfExpression() ast.Expression {
 
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.currToken}
-	block.Statements = make([]ast.Statement, 0)
 
 	p.nextToken()
+	block.Statements = p.parseStatementList(token.RBRACE)
 
-	for !p.currTokenIs(token.RBRACE) && !p.currTokenIs(token.EOF) {
-		stmt := p.parseStatement()
-		if stmt != nil {
-			block.Statements = append(block.Statements, stmt)
-		}
-		p.nextToken()
+	if !p.currTokenIs(token.RBRACE) {
+		p.addDiagnostic(Diagnostic{
+			Severity: SeverityError,
+			Pos:      p.currToken.Pos,
+			Message: fmt.Sprintf("expected next token to be '%s', get '%s'",
+				token.RBRACE, p.currToken.Type),
+			Expected: token.RBRACE,
+			Got:      p.currToken.Type,
+		})
 	}
 
 	return block
@@ -358,7 +725,12 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
+	// a function body starts a new loop context: break can't leave it
+	outerLoopDepth, outerInFinally := p.loopDepth, p.inFinally
+	p.loopDepth, p.inFinally = 0, false
 	lit.Body = p.parseBlockStatement()
+	p.loopDepth, p.inFinally = outerLoopDepth, outerInFinally
+
 	return lit
 }
 
@@ -404,6 +776,41 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.currToken, Value: p.currToken.Literal}
 }
 
+func (p *Parser) parseInterpolatedString() ast.Expression {
+	str := &ast.InterpolatedString{Token: p.currToken}
+	str.Parts = p.appendStringPart(str.Parts)
+
+	for {
+		p.nextToken()
+		str.Parts = append(str.Parts, p.parseExpression(LOWEST))
+
+		switch {
+		case p.peekTokenIs(token.TEMPLATE_MIDDLE):
+			p.nextToken()
+			str.Parts = p.appendStringPart(str.Parts)
+		case p.peekTokenIs(token.TEMPLATE_TAIL):
+			p.nextToken()
+			str.Parts = p.appendStringPart(str.Parts)
+			return str
+		default:
+			p.peekError(token.RBRACE)
+			return nil
+		}
+	}
+}
+
+// appendStringPart adds the text of the current template token to parts,
+// leaving out empty text.
+func (p *Parser) appendStringPart(parts []ast.Expression) []ast.Expression {
+	if p.currToken.Literal == "" {
+		return parts
+	}
+	return append(parts, &ast.StringLiteral{
+		Token: p.currToken,
+		Value: p.currToken.Literal,
+	})
+}
+
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.currToken}
 
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"monkey/ast"
 	"monkey/lexer"
+	"monkey/token"
 	"testing"
 )
 
@@ -181,6 +182,35 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestFloatLiteralExpression(t *testing.T) {
+	input := `2.5;`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("statement length error, want=%d, get=%d", 1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("stmt not *ast.ExpressionStatement. got=%T", program.Statements[0])
+	}
+
+	literal, ok := stmt.Expression.(*ast.FloatLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+	}
+	if literal.Value != 2.5 {
+		t.Errorf("literal.Value not %f. got=%f", 2.5, literal.Value)
+	}
+	if literal.TokenLiteral() != "2.5" {
+		t.Errorf("literal.TokenLiteral() not %s. got=%s", "2.5", literal.TokenLiteral())
+	}
+}
+
 func TestParsingPrefixExpressions(t *testing.T) {
 	prefixTests := []struct {
 		input    string
@@ -368,6 +398,42 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a + b + c * d / f + g)",
 			"add((((a + b) + ((c * d) / f)) + g))",
 		},
+		{
+			"a || b && c == d",
+			"(a || (b && (c == d)))",
+		},
+		{
+			"a && b || c && d",
+			"((a && b) || (c && d))",
+		},
+		{
+			"x = a || b",
+			"(x = (a || b))",
+		},
+		{
+			"a <= b == c >= d",
+			"((a <= b) == (c >= d))",
+		},
+		{
+			"a | b ^ c & d << e + f % g",
+			"(a | (b ^ (c & (d << (e + (f % g))))))",
+		},
+		{
+			"a & 1 == 0",
+			"((a & 1) == 0)",
+		},
+		{
+			"2 ** 3 ** 2",
+			"(2 ** (3 ** 2))",
+		},
+		{
+			"-2 ** 2",
+			"(-(2 ** 2))",
+		},
+		{
+			"~a * b",
+			"((~a) * b)",
+		},
 	}
 
 	for _, tt := range tests {
@@ -1020,3 +1086,426 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 		testFunc(value)
 	}
 }
+
+func TestNodePositions(t *testing.T) {
+	input := `let x = 5;
+add(x, 10) * y[0];`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d",
+			len(program.Statements))
+	}
+
+	letStmt := program.Statements[0].(*ast.LetStatement)
+	if pos := letStmt.Value.Pos(); pos.Line != 1 || pos.Column != 9 {
+		t.Errorf("let value position wrong. got=%s", pos)
+	}
+
+	stmt := program.Statements[1].(*ast.ExpressionStatement)
+	infix := stmt.Expression.(*ast.InfixExpression)
+	if pos := infix.Pos(); pos.Line != 2 || pos.Column != 1 {
+		t.Errorf("infix position wrong. got=%s", pos)
+	}
+	if pos := infix.Right.Pos(); pos.Line != 2 || pos.Column != 14 {
+		t.Errorf("index position wrong. got=%s", pos)
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	l := lexer.New("let x 5;")
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) == 0 {
+		t.Fatalf("expected parser errors but got none")
+	}
+	expected := "1:7: expected next token to be '=', get 'INT'"
+	if errors[0] != expected {
+		t.Errorf("wrong error. want=%q, got=%q", expected, errors[0])
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	input := `
+let x = 5;
+let = 10;
+let y = x +;
+let add = fn(a, b) {
+	let c = ;
+	a + b;
+};
+return y;
+`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+
+	expected := []struct {
+		line     int
+		column   int
+		expected token.TokenType
+		got      token.TokenType
+	}{
+		{3, 5, token.IDENT, token.ASSIGN},
+		{4, 12, "", token.SEMICOLON},
+		{6, 10, "", token.SEMICOLON},
+	}
+
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("wrong number of diagnostics. want=%d, got=%d (%v)",
+			len(expected), len(diagnostics), p.Errors())
+	}
+	for i, want := range expected {
+		d := diagnostics[i]
+		if d.Severity != SeverityError {
+			t.Errorf("diagnostics[%d] - severity wrong. got=%s", i, d.Severity)
+		}
+		if d.Pos.Line != want.line || d.Pos.Column != want.column {
+			t.Errorf("diagnostics[%d] - position wrong. want=%d:%d, got=%s",
+				i, want.line, want.column, d.Pos)
+		}
+		if d.Expected != want.expected || d.Got != want.got {
+			t.Errorf("diagnostics[%d] - tokens wrong. want=%q/%q, got=%q/%q",
+				i, want.expected, want.got, d.Expected, d.Got)
+		}
+	}
+
+	want := []string{"let x = 5;", "let add = fn<add>(a, b) (a + b);", "return y;"}
+	if len(program.Statements) != len(want) {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d",
+			len(want), len(program.Statements))
+	}
+	for i, stmt := range program.Statements {
+		if stmt.String() != want[i] {
+			t.Errorf("statement %d wrong. want=%q, got=%q", i, want[i], stmt.String())
+		}
+	}
+}
+
+func TestUnclosedBlock(t *testing.T) {
+	l := lexer.New("if (x) { 1")
+	p := New(l)
+	p.ParseProgram()
+
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != 1 {
+		t.Fatalf("wrong number of diagnostics. want=1, got=%d", len(diagnostics))
+	}
+	if diagnostics[0].Expected != token.RBRACE || diagnostics[0].Got != token.EOF {
+		t.Errorf("wrong diagnostic. got=%+v", diagnostics[0])
+	}
+}
+
+func TestAssignExpressionParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = 5;", "(x = 5)"},
+		{"x = y = 5;", "(x = (y = 5))"},
+		{"x += 1 + 2;", "(x += (1 + 2))"},
+		{"x -= 1;", "(x -= 1)"},
+		{"x *= 2;", "(x *= 2)"},
+		{"x /= 2;", "(x /= 2)"},
+		{"a[0] = b == c;", "((a[0]) = (b == c))"},
+		{`h["k"] += 1;`, "((h[k]) += 1)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+				len(program.Statements))
+		}
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		if _, ok := stmt.Expression.(*ast.AssignExpression); !ok {
+			t.Fatalf("exp not *ast.AssignExpression. got=%T", stmt.Expression)
+		}
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestInvalidAssignmentTarget(t *testing.T) {
+	l := lexer.New("1 = 2;")
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) != 1 {
+		t.Fatalf("wrong number of errors. want=1, got=%d (%v)", len(errors), errors)
+	}
+	if errors[0] != "1:3: cannot assign to 1" {
+		t.Errorf("wrong error. got=%q", errors[0])
+	}
+}
+
+func TestWhileStatement(t *testing.T) {
+	l := lexer.New("while (x < 10) { x += 1; continue; break; }")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+			len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("stmt not *ast.WhileStatement. got=%T", program.Statements[0])
+	}
+	if !testInfixExpression(t, stmt.Condition, "x", "<", 10) {
+		return
+	}
+	if len(stmt.Body.Statements) != 3 {
+		t.Fatalf("body does not contain 3 statements. got=%d",
+			len(stmt.Body.Statements))
+	}
+	if _, ok := stmt.Body.Statements[1].(*ast.ContinueStatement); !ok {
+		t.Errorf("stmt not *ast.ContinueStatement. got=%T", stmt.Body.Statements[1])
+	}
+	if _, ok := stmt.Body.Statements[2].(*ast.BreakStatement); !ok {
+		t.Errorf("stmt not *ast.BreakStatement. got=%T", stmt.Body.Statements[2])
+	}
+}
+
+func TestForStatement(t *testing.T) {
+	l := lexer.New("for (x in xs) { x; }")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+			len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ForStatement)
+	if !ok {
+		t.Fatalf("stmt not *ast.ForStatement. got=%T", program.Statements[0])
+	}
+	if !testIdentifier(t, stmt.Variable, "x") {
+		return
+	}
+	if !testIdentifier(t, stmt.Iterable, "xs") {
+		return
+	}
+	if len(stmt.Body.Statements) != 1 {
+		t.Fatalf("body does not contain 1 statements. got=%d",
+			len(stmt.Body.Statements))
+	}
+}
+
+func TestLoopControlOutsideLoop(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"break;", "1:1: break outside of loop"},
+		{"if (true) { continue; }", "1:13: continue outside of loop"},
+		{"while (true) { fn() { break; } }", "1:23: break outside of loop"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 {
+			t.Fatalf("wrong number of errors. want=1, got=%d (%v)", len(errors), errors)
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, errors[0])
+		}
+	}
+}
+
+func TestTryStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		param    string
+		catch    bool
+		finally  bool
+		expected string
+	}{
+		{"try { x; } catch (e) { e; }", "e", true, false, "try x catch (e) e"},
+		{"try { x; } catch { y; }", "", true, false, "try x catch y"},
+		{"try { x; } finally { y; }", "", false, true, "try x finally y"},
+		{"try { x; } catch (e) { e; } finally { y; }", "e", true, true, "try x catch (e) e finally y"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+				len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.TryStatement)
+		if !ok {
+			t.Fatalf("stmt not *ast.TryStatement. got=%T", program.Statements[0])
+		}
+		if len(stmt.Body.Statements) != 1 {
+			t.Fatalf("body does not contain 1 statements. got=%d",
+				len(stmt.Body.Statements))
+		}
+		if tt.param == "" && stmt.Param != nil {
+			t.Errorf("stmt.Param is not nil. got=%s", stmt.Param)
+		}
+		if tt.param != "" && !testIdentifier(t, stmt.Param, tt.param) {
+			return
+		}
+		if (stmt.Catch != nil) != tt.catch {
+			t.Errorf("stmt.Catch wrong. want present=%t, got=%v", tt.catch, stmt.Catch)
+		}
+		if (stmt.Finally != nil) != tt.finally {
+			t.Errorf("stmt.Finally wrong. want present=%t, got=%v", tt.finally, stmt.Finally)
+		}
+		if stmt.String() != tt.expected {
+			t.Errorf("stmt.String() wrong. want=%q, got=%q", tt.expected, stmt.String())
+		}
+	}
+}
+
+func TestThrowStatement(t *testing.T) {
+	l := lexer.New(`throw x + 1;`)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+			len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ThrowStatement)
+	if !ok {
+		t.Fatalf("stmt not *ast.ThrowStatement. got=%T", program.Statements[0])
+	}
+	if !testInfixExpression(t, stmt.Value, "x", "+", 1) {
+		return
+	}
+}
+
+func TestTryStatementErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"try { x; }", "1:1: try without catch or finally"},
+		{"try { x; } catch (1) { }", "1:19: expected next token to be 'IDENT', get 'INT'"},
+		{"while (true) { try { } finally { break; } }", "1:34: break out of finally block"},
+		{"for (x in xs) { try { } finally { if (x) { continue; } } }", "1:44: continue out of finally block"},
+		{"try { } finally { fn() { break; } }", "1:26: break outside of loop"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("%q: expected a parser error", tt.input)
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, errors[0])
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `
+	// adds two numbers
+	let add = fn(a, b) {
+		a + b; /* the sum */
+	};
+	add(1, /* inline */ 2) // trailing
+	`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d",
+			len(program.Statements))
+	}
+	if got := program.Statements[1].String(); got != "add(1, 2)" {
+		t.Errorf("wrong statement. got=%q", got)
+	}
+}
+
+func TestIllegalTokenErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let s = "abc`, "1:9: unterminated string"},
+		{`let s = "a\qb";`, "1:9: unknown escape sequence \\q"},
+		{"1 @ 2;", "1:3: illegal character \"@\""},
+		{"1 € 2;", "1:3: illegal character \"€\""},
+		{"let x = 1; /* open", "1:12: unterminated comment"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 {
+			t.Fatalf("wrong number of errors. want=1, got=%d (%v)", len(errors), errors)
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, errors[0])
+		}
+	}
+}
+
+func TestInterpolatedStringParsing(t *testing.T) {
+	tests := []struct {
+		input         string
+		expected      string
+		expectedParts int
+	}{
+		{`"hello ${name}!"`, `"hello ${name}!"`, 3},
+		{`"${a + b}"`, `"${(a + b)}"`, 1},
+		{`"${len(items)} items and ${"${x}"}"`, `"${len(items)} items and ${"${x}"}"`, 3},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		str, ok := stmt.Expression.(*ast.InterpolatedString)
+		if !ok {
+			t.Fatalf("exp not *ast.InterpolatedString. got=%T", stmt.Expression)
+		}
+		if len(str.Parts) != tt.expectedParts {
+			t.Errorf("wrong number of parts. want=%d, got=%d",
+				tt.expectedParts, len(str.Parts))
+		}
+		if str.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, str.String())
+		}
+	}
+}
@@ -2,6 +2,8 @@ package repl
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"monkey/compiler"
@@ -19,8 +21,8 @@ func Start(in io.Reader, out io.Writer) {
 	var constants []object.Object
 	globals := make([]object.Object, vm.GlobalsSize)
 	symbolTable := compiler.NewSymbolTable()
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
+	for i, def := range object.NewDefaultRegistry().Definitions() {
+		symbolTable.DefineBuiltin(i, def.Name)
 	}
 	for {
 		fmt.Fprintf(out, PROMPT)
@@ -50,9 +52,9 @@ func Start(in io.Reader, out io.Writer) {
 		constants = code.Constants
 
 		machine := vm.NewWithGlobalsStore(code, globals)
-		err = machine.Run()
+		err = machine.Run(context.Background())
 		if err != nil {
-			fmt.Fprintf(out, "Woops! Executing bytecode failed:\n %s\n", err)
+			printRuntimeError(out, err)
 			continue
 		}
 
@@ -62,6 +64,17 @@ func Start(in io.Reader, out io.Writer) {
 	}
 }
 
+func printRuntimeError(out io.Writer, err error) {
+	_, _ = io.WriteString(out, "Woops! Executing bytecode failed:\n")
+
+	var rtErr *vm.RuntimeError
+	if errors.As(err, &rtErr) {
+		_, _ = io.WriteString(out, rtErr.Traceback())
+		return
+	}
+	_, _ = io.WriteString(out, " "+err.Error()+"\n")
+}
+
 func printParserErrors(out io.Writer, errors []string) {
 	_, _ = io.WriteString(out, "parser error: \n")
 	for _, msg := range errors {
@@ -1,20 +1,55 @@
 package token
 
+import "fmt"
+
 type TokenType string
 
+// Position is a location in Monkey source. Line and Column are 1-based,
+// Offset is the 0-based byte offset into the input.
+type Position struct {
+	File   string
+	Line   int
+	Column int
+	Offset int
+}
+
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+func (p Position) String() string {
+	if p.File != "" {
+		return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
 type Token struct {
 	Type    TokenType
 	Literal string
+
+	// Pos is the position of the first byte of the token and End the
+	// position just past its last byte, so the byte span of the token in
+	// the source is [Pos.Offset, End.Offset).
+	Pos Position
+	End Position
 }
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
-	"true":   TRUE,
-	"false":  FALSE,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"true":     TRUE,
+	"false":    FALSE,
+	"while":    WHILE,
+	"for":      FOR,
+	"in":       IN,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"try":      TRY,
+	"catch":    CATCH,
+	"finally":  FINALLY,
+	"throw":    THROW,
 }
 
 func LookupIdent(ident string) TokenType {
@@ -25,26 +60,60 @@ func LookupIdent(ident string) TokenType {
 }
 
 const (
+	// ILLEGAL is a character that starts no token; its literal is the
+	// character
 	ILLEGAL = "ILLEGAL"
-	EOF     = "EOF"
+	// ERROR is a malformed token, such as an unterminated string; its
+	// literal describes the problem
+	ERROR = "ERROR"
+	EOF   = "EOF"
+	// COMMENT is only produced by lexers that keep comments
+	COMMENT = "COMMENT"
 
 	IDENT  = "IDENT"
 	INT    = "INT"
+	FLOAT  = "FLOAT"
 	STRING = "STRING"
 
+	// An interpolated string "a ${x} b ${y} c" is lexed as TEMPLATE_HEAD "a ",
+	// the tokens of x, TEMPLATE_MIDDLE " b ", the tokens of y and
+	// TEMPLATE_TAIL " c".
+	TEMPLATE_HEAD   = "TEMPLATE_HEAD"
+	TEMPLATE_MIDDLE = "TEMPLATE_MIDDLE"
+	TEMPLATE_TAIL   = "TEMPLATE_TAIL"
+
 	ASSIGN   = "="
 	PLUS     = "+"
 	MINUS    = "-"
 	BANG     = "!"
 	ASTERISK = "*"
 	SLASH    = "/"
+	PERCENT  = "%"
+	POWER    = "**"
 
-	LT = "<"
-	GT = ">"
+	BIT_AND = "&"
+	BIT_OR  = "|"
+	BIT_XOR = "^"
+	BIT_NOT = "~"
+	SHL     = "<<"
+	SHR     = ">>"
+
+	PLUS_ASSIGN     = "+="
+	MINUS_ASSIGN    = "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN    = "/="
+
+	LT    = "<"
+	GT    = ">"
+	LT_EQ = "<="
+	GT_EQ = ">="
 
 	EQ     = "=="
 	NOT_EQ = "!="
 
+	AND = "&&"
+	OR  = "||"
+
 	COMMA     = ","
 	SEMICOLON = ";"
 	COLON     = ":"
@@ -63,4 +132,13 @@ const (
 	RETURN   = "RETURN"
 	TRUE     = "TRUE"
 	FALSE    = "FALSE"
+	WHILE    = "WHILE"
+	FOR      = "FOR"
+	IN       = "IN"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
+	THROW    = "THROW"
 )
@@ -0,0 +1,104 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"monkey/object"
+)
+
+var (
+	// ErrTimeout is the error Run fails with when its context is done
+	// before the program ends. It is object.ErrTimeout, which the evaluator
+	// stops with too.
+	ErrTimeout = object.ErrTimeout
+
+	// ErrBudgetExceeded is the error Run fails with when the program runs
+	// out of an instruction or frame budget.
+	ErrBudgetExceeded = errors.New("execution budget exceeded")
+
+	// ErrDivisionByZero is the error of an integer division or modulo by
+	// zero.
+	ErrDivisionByZero = errors.New("division by zero")
+
+	// ErrUnsupportedIndex is wrapped by the errors of indexing a value that
+	// cannot be indexed, or with an index of the wrong type.
+	ErrUnsupportedIndex = errors.New("index operator not supported")
+
+	// ErrInternal is wrapped by the error Run fails with when executing
+	// the bytecode panics.
+	ErrInternal = errors.New("internal error")
+
+	errStackOverflow = errors.New("stack overflow")
+)
+
+// Exception is the error raised by a throw statement that no handler
+// caught. Value is the thrown value.
+type Exception struct {
+	Value object.Object
+}
+
+func (e *Exception) Error() string { return "uncaught exception: " + e.Value.Inspect() }
+
+// TraceFrame describes one call frame that was active when a runtime error
+// occurred.
+type TraceFrame struct {
+	Function string
+	Offset   int
+	Line     int
+}
+
+// RuntimeError is returned by Run when executing the bytecode fails. Trace
+// holds the call stack at the time of the failure, innermost frame first.
+type RuntimeError struct {
+	Err   error
+	Trace []TraceFrame
+}
+
+func (e *RuntimeError) Error() string { return e.Err.Error() }
+func (e *RuntimeError) Unwrap() error { return e.Err }
+
+// Traceback formats the error together with its call stack.
+func (e *RuntimeError) Traceback() string {
+	var writer bytes.Buffer
+
+	fmt.Fprintf(&writer, "runtime error: %s\n", e.Err)
+	for _, f := range e.Trace {
+		if f.Line > 0 {
+			fmt.Fprintf(&writer, "\tat %s (line %d, offset %04d)\n", f.Function, f.Line, f.Offset)
+		} else {
+			fmt.Fprintf(&writer, "\tat %s (offset %04d)\n", f.Function, f.Offset)
+		}
+	}
+
+	return writer.String()
+}
+
+func (vm *VM) newRuntimeError(err error) *RuntimeError {
+	trace := make([]TraceFrame, 0, vm.framesIndex)
+
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+		fn := frame.cl.Fn
+
+		name := fn.Name
+		if i == 0 {
+			name = "<main>"
+		} else if name == "" {
+			name = "<anonymous>"
+		}
+
+		offset := frame.ip
+		if offset < 0 {
+			offset = 0
+		}
+
+		trace = append(trace, TraceFrame{
+			Function: name,
+			Offset:   offset,
+			Line:     fn.Lines.LineFor(offset),
+		})
+	}
+
+	return &RuntimeError{Err: err, Trace: trace}
+}
@@ -1,19 +1,22 @@
 package vm
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"math"
 	"monkey/code"
 	"monkey/compiler"
 	"monkey/object"
+	"strings"
 )
 
 const StackSize = 2048
 
 var (
-	True  = &object.Boolean{Value: true}
-	False = &object.Boolean{Value: false}
-	Null  = &object.Null{}
+	True  = object.TRUE
+	False = object.FALSE
+	Null  = object.NULL
 )
 
 const GlobalsSize = 65536
@@ -28,30 +31,82 @@ type VM struct {
 
 	frames      []*Frame
 	framesIndex int
+
+	// instructionBudget and frameBudget are the budgets set by
+	// WithMaxInstructions and WithMaxFrames, 0 when there is none
+	instructionBudget int
+	frameBudget       int
+
+	// executed counts the instructions run by Run, which checks its context
+	// and the instruction budget once executed reaches nextCheck
+	executed  int
+	nextCheck int
+
+	builtins *object.Registry
 }
 
 const maxFrames = 1024
 
-func New(bytecode *compiler.Bytecode) *VM {
-	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+// budgetCheckInterval is the number of instructions run between two checks
+// of the context passed to Run.
+const budgetCheckInterval = 1024
+
+// Option configures a VM created by New or NewWithGlobalsStore.
+type Option func(*VM)
+
+// WithMaxInstructions makes Run fail with ErrBudgetExceeded once it has
+// executed n instructions.
+func WithMaxInstructions(n int) Option {
+	return func(vm *VM) { vm.instructionBudget = n }
+}
+
+// WithMaxFrames makes Run fail with ErrBudgetExceeded when a call would make
+// more than n frames, including the main one, active at the same time.
+func WithMaxFrames(n int) Option {
+	return func(vm *VM) { vm.frameBudget = n }
+}
+
+// WithBuiltins makes OpGetBuiltin load the builtins of r instead of the
+// standard ones. It must be the registry the bytecode was compiled with.
+func WithBuiltins(r *object.Registry) Option {
+	return func(vm *VM) { vm.builtins = r }
+}
+
+func New(bytecode *compiler.Bytecode, opts ...Option) *VM {
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Lines:        bytecode.Lines,
+		Handlers:     bytecode.Handlers,
+	}
 	mainClosure := &object.Closure{Fn: mainFn}
 	mainFrame := NewFrame(mainClosure, 0)
 
-	frames := make([]*Frame, maxFrames)
-	frames[0] = mainFrame
-
-	return &VM{
+	vm := &VM{
 		constants:   bytecode.Constants,
 		stack:       make([]object.Object, StackSize),
 		sp:          0,
 		globals:     make([]object.Object, GlobalsSize),
-		frames:      frames,
 		framesIndex: 1,
 	}
+	for _, opt := range opts {
+		opt(vm)
+	}
+	if vm.builtins == nil {
+		vm.builtins = object.NewDefaultRegistry()
+	}
+
+	frames := maxFrames
+	if vm.frameBudget > 0 {
+		frames = vm.frameBudget
+	}
+	vm.frames = make([]*Frame, frames)
+	vm.frames[0] = mainFrame
+
+	return vm
 }
 
-func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
-	vm := New(bytecode)
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object, opts ...Option) *VM {
+	vm := New(bytecode, opts...)
 	vm.globals = s
 	return vm
 }
@@ -77,7 +132,141 @@ func (vm *VM) StackTop() object.Object {
 	return vm.stack[vm.sp-1]
 }
 
-func (vm *VM) Run() error {
+// Run executes the bytecode. It fails with ErrTimeout once ctx is done and
+// with ErrBudgetExceeded when a budget set by WithMaxInstructions or
+// WithMaxFrames runs out. Errors are returned as *RuntimeError, including
+// ErrInternal for a panic while executing the bytecode.
+func (vm *VM) Run(ctx context.Context) (err error) {
+	defer vm.recoverPanic(&err)
+
+	vm.executed = 0
+	vm.nextCheck = 0
+	if err := vm.run(ctx, 0); err != nil {
+		return vm.newRuntimeError(err)
+	}
+	return nil
+}
+
+// Call calls fn, a closure or a builtin, with args and returns its result.
+// It is meant to be used once Run has returned, to call the functions the
+// program defined; a closure runs on top of what the program left on the
+// stack, with the same globals and under the same budgets as Run. When the
+// call fails the VM is left as it was before it.
+func (vm *VM) Call(ctx context.Context, fn object.Object, args ...object.Object) (result object.Object, err error) {
+	sp, depth := vm.sp, vm.framesIndex
+	defer func() {
+		if err != nil {
+			vm.sp, vm.framesIndex = sp, depth
+		}
+	}()
+	defer vm.recoverPanic(&err)
+
+	vm.executed = 0
+	vm.nextCheck = 0
+	if err := vm.call(ctx, depth, fn, args); err != nil {
+		return nil, vm.newRuntimeError(err)
+	}
+	return vm.pop(), nil
+}
+
+// recoverPanic turns a panic while executing the bytecode into a
+// *RuntimeError wrapping ErrInternal in *err, whose trace holds the
+// instruction pointer of every frame. Only malformed bytecode or a bug in
+// the VM should panic, and neither should bring down the program running
+// the VM.
+func (vm *VM) recoverPanic(err *error) {
+	if r := recover(); r != nil {
+		*err = vm.newRuntimeError(fmt.Errorf("%w: %v", ErrInternal, r))
+	}
+}
+
+func (vm *VM) call(ctx context.Context, depth int, fn object.Object, args []object.Object) error {
+	if err := vm.push(fn); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if err := vm.push(arg); err != nil {
+			return err
+		}
+	}
+
+	if err := vm.executeCall(len(args)); err != nil {
+		return err
+	}
+	if vm.framesIndex == depth {
+		// a builtin, whose result is already on the stack
+		return nil
+	}
+	return vm.run(ctx, depth)
+}
+
+// checkBudget fails once ctx is done or the instruction budget is spent, and
+// schedules the next check.
+func (vm *VM) checkBudget(ctx context.Context) error {
+	if vm.instructionBudget > 0 && vm.executed > vm.instructionBudget {
+		return fmt.Errorf("%w: more than %d instructions", ErrBudgetExceeded, vm.instructionBudget)
+	}
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %w", ErrTimeout, ctx.Err())
+	default:
+	}
+
+	vm.nextCheck = vm.executed + budgetCheckInterval
+	if vm.instructionBudget > 0 && vm.nextCheck > vm.instructionBudget+1 {
+		vm.nextCheck = vm.instructionBudget + 1
+	}
+	return nil
+}
+
+// run executes instructions until the main function ends or, when a frame
+// returns, fewer than depth frames are left. Exceptions raised meanwhile are
+// caught by the handlers of the frames above depth.
+func (vm *VM) run(ctx context.Context, depth int) error {
+	for {
+		err := vm.execute(ctx, depth)
+		if err == nil || !vm.catch(err, depth) {
+			return err
+		}
+	}
+}
+
+// catch looks for the innermost handler covering the instruction that
+// raised err in the frames above depth. When there is one, it unwinds the
+// frames and the stack to it, pushes the exception and reports true. Running
+// out of time, budget or stack cannot be caught.
+func (vm *VM) catch(err error, depth int) bool {
+	if errors.Is(err, ErrTimeout) || errors.Is(err, ErrBudgetExceeded) || errors.Is(err, errStackOverflow) {
+		return false
+	}
+
+	for i := vm.framesIndex - 1; i >= depth; i-- {
+		frame := vm.frames[i]
+		handler, ok := frame.cl.Fn.Handlers.Lookup(frame.ip)
+		if !ok {
+			continue
+		}
+
+		vm.framesIndex = i + 1
+		frame.ip = handler.Target - 1
+		vm.sp = frame.basePointer + frame.cl.Fn.NumLocals + handler.StackDepth
+
+		var value object.Object
+		var exc *Exception
+		if errors.As(err, &exc) {
+			value = exc.Value
+		} else {
+			value = &object.String{Value: err.Error()}
+		}
+		vm.stack[vm.sp] = value
+		vm.sp++
+		return true
+	}
+	return false
+}
+
+// execute runs instructions like run, returning the first error raised.
+func (vm *VM) execute(ctx context.Context, depth int) error {
 	var (
 		ip  int
 		ins code.Instructions
@@ -85,6 +274,13 @@ func (vm *VM) Run() error {
 	)
 
 	for vm.currentFrame().ip < len(vm.currentFrame().Instruction())-1 {
+		vm.executed++
+		if vm.executed >= vm.nextCheck {
+			if err := vm.checkBudget(ctx); err != nil {
+				return err
+			}
+		}
+
 		vm.currentFrame().ip++
 
 		ip = vm.currentFrame().ip
@@ -98,7 +294,8 @@ func (vm *VM) Run() error {
 			if err := vm.push(vm.constants[constIndex]); err != nil {
 				return err
 			}
-		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod, code.OpPow,
+			code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShiftLeft, code.OpShiftRight:
 			if err := vm.ExecuteBinaryOperation(op); err != nil {
 				return err
 			}
@@ -112,7 +309,14 @@ func (vm *VM) Run() error {
 			}
 		case code.OpPop:
 			vm.pop()
-		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
+		case code.OpDup2:
+			if err := vm.push(vm.stack[vm.sp-2]); err != nil {
+				return err
+			}
+			if err := vm.push(vm.stack[vm.sp-2]); err != nil {
+				return err
+			}
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterThanOrEqual:
 			if err := vm.executeComparison(op); err != nil {
 				return err
 			}
@@ -124,16 +328,34 @@ func (vm *VM) Run() error {
 			if err := vm.ExecuteMinusOperation(); err != nil {
 				return err
 			}
+		case code.OpBitNot:
+			operand := vm.pop()
+			integer, ok := operand.(*object.Integer)
+			if !ok {
+				return fmt.Errorf("unsupported type for bitwise not: %s", operand.Type())
+			}
+			if err := vm.push(&object.Integer{Value: ^integer.Value}); err != nil {
+				return err
+			}
 		case code.OpJump:
 			pos := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip = pos - 1
-		case code.OpJumpNotTruthy:
+		case code.OpJumpNotTruthy, code.OpJumpTruthy:
 			pos := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
 
 			condition := vm.pop()
-			if !isTruthy(condition) {
+			if isTruthy(condition) == (op == code.OpJumpTruthy) {
+				vm.currentFrame().ip = pos - 1
+			}
+		case code.OpJumpTruthyOrPop, code.OpJumpNotTruthyOrPop:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			if isTruthy(vm.stack[vm.sp-1]) == (op == code.OpJumpTruthyOrPop) {
 				vm.currentFrame().ip = pos - 1
+			} else {
+				vm.pop()
 			}
 		case code.OpNull:
 			if err := vm.push(Null); err != nil {
@@ -188,6 +410,13 @@ func (vm *VM) Run() error {
 			if err := vm.executeCall(int(numArgs)); err != nil {
 				return err
 			}
+		case code.OpTailCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			if err := vm.executeTailCall(int(numArgs)); err != nil {
+				return err
+			}
 		case code.OpReturnValue:
 			returnValue := vm.pop()
 
@@ -197,6 +426,9 @@ func (vm *VM) Run() error {
 			if err := vm.push(returnValue); err != nil {
 				return err
 			}
+			if vm.framesIndex <= depth {
+				return nil
+			}
 		case code.OpReturn:
 			frame := vm.popFrame()
 			vm.sp = frame.basePointer - 1
@@ -204,6 +436,9 @@ func (vm *VM) Run() error {
 			if err := vm.push(Null); err != nil {
 				return err
 			}
+			if vm.framesIndex <= depth {
+				return nil
+			}
 		case code.OpSetLocal:
 			localIndex := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip += 1
@@ -219,12 +454,37 @@ func (vm *VM) Run() error {
 			if err := vm.push(vm.stack[frame.basePointer+int(localIndex)]); err != nil {
 				return err
 			}
+		case code.OpGetLocalConstAdd, code.OpGetLocalConstSub:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			constIndex := code.ReadUint16(ins[ip+2:])
+			vm.currentFrame().ip += 3
+
+			frame := vm.currentFrame()
+			local := vm.stack[frame.basePointer+int(localIndex)]
+			if err := vm.executeLocalConstArithmetic(op, local, vm.constants[constIndex]); err != nil {
+				return err
+			}
+		case code.OpCompareJump:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			cmp := code.Opcode(code.ReadUint8(ins[ip+3:]))
+			vm.currentFrame().ip += 3
+
+			if err := vm.executeCompareJump(cmp, pos); err != nil {
+				return err
+			}
+		case code.OpCall1:
+			if err := vm.executeCall(1); err != nil {
+				return err
+			}
 		case code.OpGetBuiltin:
 			builtinIndex := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip += 1
 
-			definition := object.Builtins[builtinIndex]
-			if err := vm.push(definition.Builtin); err != nil {
+			builtin, ok := vm.builtins.Get(int(builtinIndex))
+			if !ok {
+				return fmt.Errorf("undefined builtin %d", builtinIndex)
+			}
+			if err := vm.push(builtin); err != nil {
 				return err
 			}
 		case code.OpClosure:
@@ -248,6 +508,94 @@ func (vm *VM) Run() error {
 			if err := vm.push(currentClosure); err != nil {
 				return err
 			}
+		case code.OpMakeCell:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			slot := vm.currentFrame().basePointer + int(localIndex)
+			vm.stack[slot] = &object.Cell{Value: vm.stack[slot]}
+		case code.OpGetLocalCell:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			cell, err := asCell(vm.stack[vm.currentFrame().basePointer+int(localIndex)])
+			if err != nil {
+				return err
+			}
+			if err := vm.push(cell.Value); err != nil {
+				return err
+			}
+		case code.OpSetLocalCell:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			cell, err := asCell(vm.stack[vm.currentFrame().basePointer+int(localIndex)])
+			if err != nil {
+				return err
+			}
+			cell.Value = vm.pop()
+		case code.OpGetFreeCell:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			cell, err := asCell(vm.currentFrame().cl.Free[freeIndex])
+			if err != nil {
+				return err
+			}
+			if err := vm.push(cell.Value); err != nil {
+				return err
+			}
+		case code.OpSetFreeCell:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			cell, err := asCell(vm.currentFrame().cl.Free[freeIndex])
+			if err != nil {
+				return err
+			}
+			cell.Value = vm.pop()
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+
+			if err := vm.executeSetIndex(left, index, value); err != nil {
+				return err
+			}
+		case code.OpConcat:
+			numParts := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			str := concat(vm.stack[vm.sp-numParts : vm.sp])
+			vm.sp = vm.sp - numParts
+			if err := vm.push(str); err != nil {
+				return err
+			}
+		case code.OpGetIter:
+			iterable := vm.pop()
+			iter, ok := object.NewIterator(iterable)
+			if !ok {
+				return fmt.Errorf("not iterable: %s", iterable.Type())
+			}
+			if err := vm.push(iter); err != nil {
+				return err
+			}
+		case code.OpThrow:
+			return &Exception{Value: vm.pop()}
+		case code.OpIterNext:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			iter := vm.stack[vm.sp-1].(*object.Iterator)
+			item, ok := iter.Next()
+			if !ok {
+				vm.pop()
+				vm.currentFrame().ip = pos - 1
+				continue
+			}
+			if err := vm.push(item); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -266,7 +614,7 @@ func isTruthy(obj object.Object) bool {
 
 func (vm *VM) push(o object.Object) error {
 	if vm.sp >= StackSize {
-		return errors.New("stack overflow")
+		return errStackOverflow
 	}
 
 	vm.stack[vm.sp] = o
@@ -296,7 +644,8 @@ func (vm *VM) ExecuteBinaryOperation(op code.Opcode) error {
 		return vm.executeBinaryIntegerOperation(op, left, right)
 	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
 		return vm.executeBinaryStringOperation(op, left, right)
-
+	case object.IsNumber(left) && object.IsNumber(right):
+		return vm.executeBinaryFloatOperation(op, left, right)
 	}
 
 	return fmt.Errorf("unsupported types for binary operation: %s %s",
@@ -319,8 +668,35 @@ func (vm *VM) executeBinaryIntegerOperation(
 		result = leftValue - rightValue
 	case code.OpMul:
 		result = leftValue * rightValue
-	case code.OpDiv:
-		result = leftValue / rightValue
+	case code.OpDiv, code.OpMod:
+		if rightValue == 0 {
+			return ErrDivisionByZero
+		}
+		if op == code.OpDiv {
+			result = leftValue / rightValue
+		} else {
+			result = leftValue % rightValue
+		}
+	case code.OpPow:
+		if rightValue < 0 {
+			return fmt.Errorf("negative exponent: %d", rightValue)
+		}
+		result = object.IntPow(leftValue, rightValue)
+	case code.OpBitAnd:
+		result = leftValue & rightValue
+	case code.OpBitOr:
+		result = leftValue | rightValue
+	case code.OpBitXor:
+		result = leftValue ^ rightValue
+	case code.OpShiftLeft, code.OpShiftRight:
+		if rightValue < 0 {
+			return fmt.Errorf("negative shift count: %d", rightValue)
+		}
+		if op == code.OpShiftLeft {
+			result = leftValue << rightValue
+		} else {
+			result = leftValue >> rightValue
+		}
 	default:
 		return fmt.Errorf("unknown integer operator: %d", op)
 	}
@@ -328,6 +704,37 @@ func (vm *VM) executeBinaryIntegerOperation(
 	return vm.push(&object.Integer{Value: result})
 }
 
+// executeBinaryFloatOperation pushes the result of an arithmetic opcode
+// with a float operand, converting an integer on the other side.
+func (vm *VM) executeBinaryFloatOperation(
+	op code.Opcode,
+	left, right object.Object,
+) error {
+	leftValue := object.ToFloat(left)
+	rightValue := object.ToFloat(right)
+
+	var result float64
+
+	switch op {
+	case code.OpAdd:
+		result = leftValue + rightValue
+	case code.OpSub:
+		result = leftValue - rightValue
+	case code.OpMul:
+		result = leftValue * rightValue
+	case code.OpDiv:
+		result = leftValue / rightValue
+	case code.OpMod:
+		result = math.Mod(leftValue, rightValue)
+	case code.OpPow:
+		result = math.Pow(leftValue, rightValue)
+	default:
+		return fmt.Errorf("unknown float operator: %d", op)
+	}
+
+	return vm.push(&object.Float{Value: result})
+}
+
 func (vm *VM) executeComparison(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
@@ -335,6 +742,9 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
 		return vm.executeIntegerComparison(op, left, right)
 	}
+	if object.IsNumber(left) && object.IsNumber(right) {
+		return vm.executeFloatComparison(op, left, right)
+	}
 
 	switch op {
 	case code.OpEqual:
@@ -347,6 +757,65 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 	}
 }
 
+// executeLocalConstArithmetic runs OpGetLocalConstAdd and
+// OpGetLocalConstSub, doing integer arithmetic without going through the
+// stack.
+func (vm *VM) executeLocalConstArithmetic(op code.Opcode, left, right object.Object) error {
+	leftInt, ok := left.(*object.Integer)
+	rightInt, ok2 := right.(*object.Integer)
+	if ok && ok2 {
+		if op == code.OpGetLocalConstAdd {
+			return vm.push(&object.Integer{Value: leftInt.Value + rightInt.Value})
+		}
+		return vm.push(&object.Integer{Value: leftInt.Value - rightInt.Value})
+	}
+
+	if err := vm.push(left); err != nil {
+		return err
+	}
+	if err := vm.push(right); err != nil {
+		return err
+	}
+	if op == code.OpGetLocalConstAdd {
+		return vm.ExecuteBinaryOperation(code.OpAdd)
+	}
+	return vm.ExecuteBinaryOperation(code.OpSub)
+}
+
+// executeCompareJump runs OpCompareJump: it compares the two values on top
+// of the stack with cmp and jumps to pos if the result is false.
+func (vm *VM) executeCompareJump(cmp code.Opcode, pos int) error {
+	var result bool
+
+	left, ok := vm.stack[vm.sp-2].(*object.Integer)
+	right, ok2 := vm.stack[vm.sp-1].(*object.Integer)
+	if ok && ok2 {
+		vm.sp -= 2
+		switch cmp {
+		case code.OpEqual:
+			result = left.Value == right.Value
+		case code.OpNotEqual:
+			result = left.Value != right.Value
+		case code.OpGreaterThan:
+			result = left.Value > right.Value
+		case code.OpGreaterThanOrEqual:
+			result = left.Value >= right.Value
+		default:
+			return fmt.Errorf("unknown operator: %d", cmp)
+		}
+	} else {
+		if err := vm.executeComparison(cmp); err != nil {
+			return err
+		}
+		result = isTruthy(vm.pop())
+	}
+
+	if !result {
+		vm.currentFrame().ip = pos - 1
+	}
+	return nil
+}
+
 func nativeBoolToBooleanObject(input bool) object.Object {
 	if input {
 		return True
@@ -368,6 +837,29 @@ func (vm *VM) executeIntegerComparison(
 		return vm.push(nativeBoolToBooleanObject(rightValue != leftValue))
 	case code.OpGreaterThan:
 		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpGreaterThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
+	default:
+		return fmt.Errorf("unknown operator: %d", op)
+	}
+}
+
+func (vm *VM) executeFloatComparison(
+	op code.Opcode,
+	left, right object.Object,
+) error {
+	leftValue := object.ToFloat(left)
+	rightValue := object.ToFloat(right)
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(rightValue == leftValue))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(rightValue != leftValue))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpGreaterThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
 	default:
 		return fmt.Errorf("unknown operator: %d", op)
 	}
@@ -390,12 +882,14 @@ func (vm *VM) ExecuteBangOperation() error {
 func (vm *VM) ExecuteMinusOperation() error {
 	operand := vm.pop()
 
-	if operand.Type() != object.INTEGER_OBJ {
+	switch operand := operand.(type) {
+	case *object.Integer:
+		return vm.push(&object.Integer{Value: -operand.Value})
+	case *object.Float:
+		return vm.push(&object.Float{Value: -operand.Value})
+	default:
 		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
 	}
-
-	value := operand.(*object.Integer).Value
-	return vm.push(&object.Integer{Value: -value})
 }
 
 func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Object) error {
@@ -440,37 +934,64 @@ func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
 }
 
 func (vm *VM) executeIndexExpression(left, index object.Object) error {
-	switch {
-	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+	switch left := left.(type) {
+	case *object.Array:
 		return vm.executeArrayIndex(left, index)
-	case left.Type() == object.HASH_OBJ:
+	case *object.String:
+		return vm.executeStringIndex(left, index)
+	case *object.Hash:
 		return vm.executeHashIndex(left, index)
 	default:
-		return fmt.Errorf("index operator not supported: %s", left.Type())
+		return fmt.Errorf("%w: %s", ErrUnsupportedIndex, left.Type())
 	}
 }
 
-func (vm *VM) executeArrayIndex(array, index object.Object) error {
-	arrayObject := array.(*object.Array)
-	i := index.(*object.Integer).Value
-	m := int64(len(arrayObject.Elements) - 1)
+func concat(parts []object.Object) *object.String {
+	var out strings.Builder
+	for _, part := range parts {
+		out.WriteString(part.Inspect())
+	}
+	return &object.String{Value: out.String()}
+}
 
-	if i < 0 || i > m {
+// executeStringIndex pushes the character at a rune index as a string.
+func (vm *VM) executeStringIndex(str *object.String, index object.Object) error {
+	integer, ok := index.(*object.Integer)
+	if !ok {
+		return fmt.Errorf("%w: %s[%s]", ErrUnsupportedIndex, str.Type(), index.Type())
+	}
+	runes := []rune(str.Value)
+	i := integer.Value
+
+	if i < 0 || i >= int64(len(runes)) {
 		return vm.push(Null)
 	}
 
-	return vm.push(arrayObject.Elements[i])
+	return vm.push(&object.String{Value: string(runes[i])})
 }
 
-func (vm *VM) executeHashIndex(hash, index object.Object) error {
-	hashObject := hash.(*object.Hash)
+func (vm *VM) executeArrayIndex(array *object.Array, index object.Object) error {
+	integer, ok := index.(*object.Integer)
+	if !ok {
+		return fmt.Errorf("%w: %s[%s]", ErrUnsupportedIndex, array.Type(), index.Type())
+	}
+	i := integer.Value
+	m := int64(len(array.Elements) - 1)
+
+	if i < 0 || i > m {
+		return vm.push(Null)
+	}
+
+	return vm.push(array.Elements[i])
+}
 
+func (vm *VM) executeHashIndex(hash *object.Hash, index object.Object) error {
 	key, ok := index.(object.Hashable)
 	if !ok {
 		return fmt.Errorf("unusable as hash key: %s", index.Type())
 	}
 
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	pair, ok := hash.Pairs[key.HashKey()]
 	if !ok {
 		return vm.push(Null)
 	}
@@ -478,11 +999,51 @@ func (vm *VM) executeHashIndex(hash, index object.Object) error {
 	return vm.push(pair.Value)
 }
 
+func asCell(obj object.Object) (*object.Cell, error) {
+	cell, ok := obj.(*object.Cell)
+	if !ok {
+		return nil, fmt.Errorf("variable is not a cell: %T", obj)
+	}
+	return cell, nil
+}
+
+// executeSetIndex stores value at left[index] and pushes the stored value.
+// A non-zero arithmetic opcode first combines the current element with value.
+func (vm *VM) executeSetIndex(left, index, value object.Object) error {
+	switch left := left.(type) {
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return fmt.Errorf("%w: %s[%s]", ErrUnsupportedIndex, left.Type(), index.Type())
+		}
+		if i.Value < 0 || i.Value >= int64(len(left.Elements)) {
+			return fmt.Errorf("index out of range: %d", i.Value)
+		}
+		left.Elements[i.Value] = value
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		left.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: value}
+	default:
+		return fmt.Errorf("index assignment not supported: %s", left.Type())
+	}
+
+	return vm.push(value)
+}
+
 func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 	if numArgs != cl.Fn.NumParameters {
 		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
 			cl.Fn.NumParameters, numArgs)
 	}
+	if vm.framesIndex >= len(vm.frames) {
+		if vm.frameBudget > 0 {
+			return fmt.Errorf("%w: more than %d frames", ErrBudgetExceeded, vm.frameBudget)
+		}
+		return errStackOverflow
+	}
 	frame := NewFrame(cl, vm.sp-numArgs)
 	vm.pushFrame(frame)
 
@@ -503,16 +1064,44 @@ func (vm *VM) executeCall(numArgs int) error {
 	}
 }
 
+// executeTailCall makes a call in tail position. A closure takes over the
+// current frame, so it returns straight to our caller and recursion through
+// tail calls runs in constant stack. Anything else is called as usual.
+func (vm *VM) executeTailCall(numArgs int) error {
+	cl, ok := vm.stack[vm.sp-1-numArgs].(*object.Closure)
+	if !ok {
+		return vm.executeCall(numArgs)
+	}
+	if numArgs != cl.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
+			cl.Fn.NumParameters, numArgs)
+	}
+
+	frame := vm.currentFrame()
+	copy(vm.stack[frame.basePointer-1:], vm.stack[vm.sp-1-numArgs:vm.sp])
+	frame.cl = cl
+	frame.ip = -1
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+
+	return nil
+}
+
 func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp]
 
 	result := builtin.Fn(args...)
 	vm.sp = vm.sp - numArgs - 1
 
-	if result != nil {
+	switch result := result.(type) {
+	case nil:
+		return vm.push(Null)
+	case *object.Error:
+		// builtins report errors by returning them; raise them instead, so
+		// that programs can catch them
+		return errors.New(result.Message)
+	default:
 		return vm.push(result)
 	}
-	return vm.push(Null)
 }
 
 func (vm *VM) pushClosure(constIndex, numFree int) error {
@@ -1,13 +1,18 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"monkey/ast"
+	"monkey/code"
 	"monkey/compiler"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"strings"
 	"testing"
+	"time"
 )
 
 type vmTestCase struct {
@@ -34,6 +39,43 @@ func TestIntegerArithmetic(t *testing.T) {
 		{"-10", -10},
 		{"-50 + 100 + -50", 0},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"3 ** 0", 1},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"1 << 4", 16},
+		{"256 >> 2", 64},
+		{"~5", -6},
+		{"1 + 2 & 3", 3},
+	})
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{"2.5", 2.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2.0},
+		{"5 / 2.0", 2.5},
+		{"2.5 - 3", -0.5},
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"1.0 == 1", true},
+		{"1.5 != 1.5", false},
+		{"int(2.9)", 2},
+		{`int("42")`, 42},
+		{"float(3)", 3.0},
+		{`float("0.25")`, 0.25},
+		{"7.5 % 2", 1.5},
+		{"2 ** 0.5", 1.4142135623730951},
+		{"2.0 ** 2", 4.0},
+		{"1.5 <= 1.5", true},
+		{"1 >= 1.5", false},
 	})
 }
 
@@ -65,6 +107,12 @@ func TestBooleanExpressions(t *testing.T) {
 		{"1 != 1", false},
 		{"1 == 2", false},
 		{"1 != 2", true},
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{"3 >= 2", true},
 		{"true == true", true},
 		{"false == false", true},
 		{"true == false", false},
@@ -153,7 +201,7 @@ func runVmTests(t *testing.T, tests []vmTestCase) {
 		}
 
 		vm := New(comp.Bytecode())
-		if err := vm.Run(); err != nil {
+		if err := vm.Run(context.Background()); err != nil {
 			t.Fatalf("vm error: %s", err)
 		}
 
@@ -174,6 +222,10 @@ func TestIndexExpressions(t *testing.T) {
 		{"{1: 1, 2: 2}[2]", 2},
 		{"{1: 1}[0]", Null},
 		{"{}[0]", Null},
+		{`"héllo"[1]`, "é"},
+		{`"héllo"[4]`, "o"},
+		{`"héllo"[5]`, Null},
+		{`"héllo"[-1]`, Null},
 	})
 }
 
@@ -417,7 +469,7 @@ func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 		}
 
 		vm := New(comp.Bytecode())
-		err = vm.Run()
+		err = vm.Run(context.Background())
 		if err == nil {
 			t.Fatalf("expected VM error but resulted in none.")
 		}
@@ -433,47 +485,51 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
-		{
-			`len(1)`,
-			&object.Error{
-				Message: "argument to `len` not supported, got INTEGER",
-			},
-		},
-		{`len("one", "two")`,
-			&object.Error{
-				Message: "wrong number of arguments. got=2, want=1",
-			},
-		},
+		{`len("naïve 世界")`, 8},
 		{`len([1, 2, 3])`, 3},
 		{`len([])`, 0},
 		{`puts("hello", "world!")`, Null},
 		{`first([1, 2, 3])`, 1},
 		{`first([])`, Null},
-		{`first(1)`,
-			&object.Error{
-				Message: "argument to `first` must be ARRAY, got INTEGER",
-			},
-		},
 		{`last([1, 2, 3])`, 3},
 		{`last([])`, Null},
-		{`last(1)`,
-			&object.Error{
-				Message: "argument to `last` must be ARRAY, got INTEGER",
-			},
-		},
 		{`rest([1, 2, 3])`, []int{2, 3}},
 		{`rest([])`, Null},
 		{`push([], 1)`, []int{1}},
-		{`push(1, 1)`,
-			&object.Error{
-				Message: "argument to `push` must be ARRAY, got INTEGER",
-			},
-		},
 	}
 
 	runVmTests(t, tests)
 }
 
+func TestBuiltinErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`len(1)`, "argument to `len` not supported, got INTEGER"},
+		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
+		{`first(1)`, "argument to `first` must be ARRAY, got INTEGER"},
+		{`last(1)`, "argument to `last` must be ARRAY, got INTEGER"},
+		{`push(1, 1)`, "argument to `push` must be ARRAY, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err := vm.Run(context.Background())
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
 func TestClosures(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -643,6 +699,12 @@ func testExpectedObject(
 			t.Errorf("testIntegerObject failed: %s", err)
 		}
 
+	case float64:
+		err := testFloatObject(expected, actual)
+		if err != nil {
+			t.Errorf("testFloatObject failed: %s", err)
+		}
+
 	case bool:
 		err := testBooleanObject(expected, actual)
 		if err != nil {
@@ -731,6 +793,21 @@ func testStringObject(expected string, actual object.Object) error {
 	return nil
 }
 
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T (%+v)",
+			actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%f, want=%f",
+			result.Value, expected)
+	}
+
+	return nil
+}
+
 func testBooleanObject(expected bool, actual object.Object) error {
 	result, ok := actual.(*object.Boolean)
 	if !ok {
@@ -766,3 +843,695 @@ func testIntegerObject(expected int64, actual object.Object) error {
 
 	return nil
 }
+
+func TestRuntimeErrorTraceback(t *testing.T) {
+	input := `let inner = fn(a) { a };
+let outer = fn() {
+	inner();
+};
+outer();`
+
+	program := parse(input)
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err := vm.Run(context.Background())
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+
+	rtErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("error is not *RuntimeError. got=%T (%+v)", err, err)
+	}
+
+	expected := []struct {
+		function string
+		line     int
+	}{
+		{"outer", 3},
+		{"<main>", 5},
+	}
+
+	if len(rtErr.Trace) != len(expected) {
+		t.Fatalf("wrong number of frames. want=%d, got=%d\n%s",
+			len(expected), len(rtErr.Trace), rtErr.Traceback())
+	}
+	for i, want := range expected {
+		frame := rtErr.Trace[i]
+		if frame.Function != want.function || frame.Line != want.line {
+			t.Errorf("frame %d wrong. want=%s:%d, got=%s:%d",
+				i, want.function, want.line, frame.Function, frame.Line)
+		}
+	}
+
+	traceback := rtErr.Traceback()
+	want := "runtime error: wrong number of arguments: want=1, got=0\n"
+	if !strings.HasPrefix(traceback, want) {
+		t.Errorf("traceback has wrong header. got=%q", traceback)
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = 1; a = 2; a;", 2},
+		{"let a = 1; a = 2;", 2},
+		{"let a = 1; let b = 2; a = b = 3; a + b;", 6},
+		{"let a = 10; a += 5; a -= 3; a *= 2; a /= 4; a;", 6},
+		{"let a = [1, 2, 3]; a[1] = 5; a[1];", 5},
+		{"let a = [1, 2, 3]; a[2] += 10; a[2];", 13},
+		{"let c = 0; let k = fn() { c = 10; 1 }; c += k(); c;", 1},
+		{"let a = [0]; let k = fn() { a[0] = 10; 1 }; a[0] += k(); a[0];", 1},
+		{`let h = {"n": 1}; let k = fn() { h["n"] = 10; 2 }; h["n"] *= k(); h["n"];`, 2},
+		{`let h = {"k": 1}; h["k"] = 2; h["n"] = 3; h["k"] + h["n"];`, 5},
+		{"let a = 1; let f = fn() { a = a + 1; }; f(); f(); a;", 3},
+		{"let f = fn() { f = 1 }; f(); f;", 1},
+		{"let f = fn(n) { if (n == 0) { f = 99; 0 } else { f(n - 1) } }; f(3); f;", 99},
+		{"fn() { let f = fn() { f = 5 }; f(); f }()", 5},
+		{"fn() { let f = fn(n) { if (n > 0) { f(n - 1) } else { f = 7 } }; f(2); f }()", 7},
+		{"fn(x) { x = x * 2; x }(21)", 42},
+		{"fn() { let x = 1; x += 2; x }()", 3},
+		{
+			input: `
+			let counter = fn() {
+				let count = 0;
+				fn() { count += 1; count };
+			};
+			let c = counter();
+			c(); c(); c();
+			`,
+			expected: 3,
+		},
+		{
+			input: `
+			let pair = fn(start) {
+				let get = fn() { start };
+				let inc = fn() { start = start + 1; };
+				[get, inc];
+			};
+			let p = pair(10);
+			p[1](); p[1]();
+			p[0]();
+			`,
+			expected: 12,
+		},
+		{
+			input: `
+			let outer = fn() {
+				let x = 1;
+				let middle = fn() {
+					fn() { x *= 10; };
+				};
+				middle()();
+				x;
+			};
+			outer();
+			`,
+			expected: 10,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestAssignmentErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = [1]; a[5] = 1;", "index out of range: 5"},
+		{"let a = 1; a[0] = 1;", "index assignment not supported: INTEGER"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err := vm.Run(context.Background())
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let i = 0; while (i < 5) { i += 1; } i;", 5},
+		{"let i = 0; while (true) { i += 1; if (i > 2) { break; } } i;", 3},
+		{
+			`let i = 0; let sum = 0;
+			while (i < 5) { i += 1; if (i == 2) { continue; } sum += i; }
+			sum;`,
+			13,
+		},
+		{"let sum = 0; for (x in [1, 2, 3]) { sum += x; } sum;", 6},
+		{`let n = 0; for (k in {"a": 1, "b": 2}) { n += 1; } n;`, 2},
+		{`let n = 0; for (c in "héllo") { n += 1; } n;`, 5},
+		{
+			`let n = 0;
+			for (x in [1, 2, 3]) {
+				for (y in [1, 2, 3]) { if (y > x) { break; } n += 1; }
+			}
+			n;`,
+			6,
+		},
+		{"fn() { for (x in [1, 2, 3]) { if (x == 2) { return x * 10; } } 0 }()", 20},
+		{"fn() { let n = 0; while (n < 3) { n += 1; } }()", Null},
+		{
+			`let fs = [];
+			fn() { for (x in [1, 2]) { fs = push(fs, fn() { x }); } }();
+			fs[0]() + fs[1]() * 10;`,
+			21,
+		},
+		{"if (true) { let z = 1; }", Null},
+		{
+			`let i = 0;
+			while (i < 5000) { i += 1; [1, if (true) { continue } else { 1 }] }
+			i;`,
+			5000,
+		},
+		{
+			`let n = 0; let ten = [0, 1, 2, 3, 4, 5, 6, 7, 8, 9];
+			for (a in ten) { for (b in ten) { for (c in ten) { for (d in ten) {
+				n += 1; 1 + [2, if (true) { continue } else { 0 }][1]
+			} } } }
+			n;`,
+			10000,
+		},
+		{
+			`let n = 0;
+			for (x in [1, 2, 3, 4]) {
+				n = n + [x, if (x == 2) { continue } else { x }][1];
+				[1, 2, if (x == 3) { break } else { 0 }]
+			}
+			n;`,
+			4,
+		},
+		{
+			`let s = 0;
+			for (x in [1, 2, 3]) { for (y in [10, 20]) { s = s + [y, if (y == 20) { break } else { y }][1] } }
+			s;`,
+			30,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestLogicalExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"true && true", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && 2", 2},
+		{"0 || 2", 0},
+		{"false || 2", 2},
+		{"1 < 2 && 2 < 3", true},
+		{"let n = 0; false && (n = 1); n", 0},
+		{"let n = 0; true || (n = 1); n", 0},
+		{"let n = 0; true && (n = 1); n", 1},
+		{"if (false || [][0]) { 1 } else { 2 }", 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestInterpolatedStrings(t *testing.T) {
+	tests := []vmTestCase{
+		{`let name = "bob"; "hello ${name}!"`, "hello bob!"},
+		{`let items = [1, 2]; "${len(items)} items: ${items}"`, "2 items: [1, 2]"},
+		{`"${1.5 * 2} ${true} ${"x"}"`, "3.0 true x"},
+		{`"${ "in${1 + 1}" }"`, "in2"},
+		{`"\${x}"`, "${x}"},
+		{`fn(n) { "n=${n}" }(3)`, "n=3"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRunUnmarshaledBytecode(t *testing.T) {
+	input := `
+	let fib = fn(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) };
+	let adder = fn(x) { fn(y) { x + y } };
+	"${fib(10)} ${adder(0.5)(1)}"
+	`
+
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	data, err := comp.Bytecode().MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error: %s", err)
+	}
+
+	bytecode := &compiler.Bytecode{}
+	if err := bytecode.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary error: %s", err)
+	}
+
+	vm := New(bytecode)
+	if err := vm.Run(context.Background()); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if err := testStringObject("55 1.5", vm.LastPoppedStackElem()); err != nil {
+		t.Errorf("testStringObject failed: %s", err)
+	}
+}
+
+func TestOperationErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		sentinel error
+	}{
+		{"1 / 0", "division by zero", ErrDivisionByZero},
+		{"let x = 0; 5 % x", "division by zero", ErrDivisionByZero},
+		{"let a = [4]; a[0] /= 0", "division by zero", ErrDivisionByZero},
+		{`[1, 2]["a"]`, "index operator not supported: ARRAY[STRING]", ErrUnsupportedIndex},
+		{`"abc"[true]`, "index operator not supported: STRING[BOOLEAN]", ErrUnsupportedIndex},
+		{"1[0]", "index operator not supported: INTEGER", ErrUnsupportedIndex},
+		{`let a = [1]; a["x"] = 1`, "index operator not supported: ARRAY[STRING]", ErrUnsupportedIndex},
+	}
+
+	for _, tt := range tests {
+		for _, optimize := range []bool{false, true} {
+			comp := compiler.New(compiler.WithOptimizations(optimize))
+			if err := comp.Compile(parse(tt.input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+
+			err := New(comp.Bytecode()).Run(context.Background())
+			if err == nil {
+				t.Fatalf("%q: expected VM error but resulted in none.", tt.input)
+			}
+			if err.Error() != tt.expected {
+				t.Errorf("wrong VM error: want=%q, got=%q", tt.expected, err)
+			}
+			if !errors.Is(err, tt.sentinel) {
+				t.Errorf("%q: error does not wrap %v", tt.input, tt.sentinel)
+			}
+		}
+	}
+}
+
+func TestRunRecoversPanics(t *testing.T) {
+	// popping an empty stack
+	bytecode := &compiler.Bytecode{
+		Instructions: append(append(code.Make(code.OpTrue),
+			code.Make(code.OpPop)...),
+			code.Make(code.OpPop)...),
+	}
+
+	err := New(bytecode).Run(context.Background())
+	if !errors.Is(err, ErrInternal) {
+		t.Fatalf("wrong error. got=%v", err)
+	}
+	rtErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("error is not *RuntimeError. got=%T", err)
+	}
+	if len(rtErr.Trace) != 1 || rtErr.Trace[0].Offset != 2 {
+		t.Errorf("wrong trace. got=%+v", rtErr.Trace)
+	}
+
+	// a closure whose instructions refer to a missing constant
+	fn := &object.Closure{Fn: &object.CompiledFunction{
+		Instructions: code.Make(code.OpConstant, 7),
+	}}
+	vm := New(&compiler.Bytecode{})
+	if _, err := vm.Call(context.Background(), fn); !errors.Is(err, ErrInternal) {
+		t.Fatalf("wrong error from Call. got=%v", err)
+	}
+	if vm.sp != 0 || vm.framesIndex != 1 {
+		t.Errorf("Call left the VM changed. sp=%d, framesIndex=%d", vm.sp, vm.framesIndex)
+	}
+}
+
+func TestOptimizationsPreserveResults(t *testing.T) {
+	inputs := []string{
+		"1 + 2 * 3 - 4 / 2 % 3",
+		"-(5 - 10) << 2 | 1 ^ ~3 & 7",
+		"!true == !!false",
+		`"mon" + "key"`,
+		"1 < 2 && 3 >= 3 || false",
+		"0 && 1",
+		"if (1 > 2) { 10 } else { 20 }",
+		"if (!true) { 10 }",
+		"let x = 2; if (true) { x * 3 }",
+		"let f = fn() { if (false) { return 1 } 2 }; f()",
+		"let x = false; if (!x) { 1 } else { 2 }",
+		"let i = 0; let done = false; while (!done) { i += 1; if (i == 3) { done = true } }; i",
+		"let f = fn(x) { if (x > 1) { return x } else { return 0 } }; f(2) + f(1)",
+		"let f = fn(x) { x + 1.5 }; f(1)",
+		`let f = fn(s) { s + "!" }; f("hi")`,
+		"let f = fn(a, b) { if (a == b) { 1 } else { 2 } }; f(true, true) + f(1, 2)",
+		"let f = fn(a) { if (a >= 1.5) { 1 } else { 2 } }; f(2) + f(1)",
+		"let fib = fn(x) { if (x < 2) { x } else { fib(x - 1) + fib(x - 2) } }; fib(15)",
+	}
+
+	for _, input := range inputs {
+		var results [2]string
+		for i, optimize := range []bool{false, true} {
+			comp := compiler.New(compiler.WithOptimizations(optimize))
+			if err := comp.Compile(parse(input)); err != nil {
+				t.Fatalf("%q: compiler error: %s", input, err)
+			}
+			vm := New(comp.Bytecode())
+			if err := vm.Run(context.Background()); err != nil {
+				t.Fatalf("%q: vm error: %s", input, err)
+			}
+			results[i] = vm.LastPoppedStackElem().Inspect()
+		}
+		if results[0] != results[1] {
+			t.Errorf("%q: optimized result %s, want %s", input, results[1], results[0])
+		}
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let countdown = fn(n) { if (n == 0) { "done" } else { countdown(n - 1) } };
+			countdown(1000000);
+			`,
+			expected: "done",
+		},
+		{
+			input: `
+			let sum = fn(n, acc) { if (n == 0) { return acc; } return sum(n - 1, acc + n); };
+			sum(100000, 0);
+			`,
+			expected: 5000050000,
+		},
+		{
+			input: `
+			let loop = fn(n, step) { if (n == 0) { 0 } else { step(n, loop) } };
+			let step = fn(n, next) { next(n - 1, step) };
+			loop(100000, step);
+			`,
+			expected: 0,
+		},
+		{
+			input: `
+			let makeCounter = fn(limit) {
+				let count = fn(n) { if (n == limit) { n } else { count(n + 1) } };
+				count
+			};
+			makeCounter(50000)(0);
+			`,
+			expected: 50000,
+		},
+		{
+			input:    `let f = fn(a) { len(a) }; f("four") + 1`,
+			expected: 5,
+		},
+		{
+			input:    `let g = fn(n) { n * 2 }; let f = fn(n) { 1 + g(n) }; f(3) + f(4)`,
+			expected: 16,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestStackOverflow(t *testing.T) {
+	input := `let deep = fn(n) { if (n == 0) { 0 } else { 1 + deep(n - 1) } }; deep(100000)`
+
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err := vm.Run(context.Background())
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+	if !strings.HasPrefix(err.Error(), "stack overflow") {
+		t.Errorf("wrong error. got=%q", err.Error())
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	inputs := []string{
+		"while (true) { }",
+		"let f = fn() { f() }; f()",
+		"try { while (true) { } } catch (e) { }",
+	}
+
+	for _, input := range inputs {
+		comp := compiler.New()
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		err := New(comp.Bytecode()).Run(ctx)
+		cancel()
+
+		if !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%q: wrong error. got=%v", input, err)
+		}
+		if _, ok := err.(*RuntimeError); !ok {
+			t.Errorf("%q: error is not *RuntimeError. got=%T", input, err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	comp := compiler.New()
+	if err := comp.Compile(parse("1")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	if err := New(comp.Bytecode()).Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("wrong error for canceled context. got=%v", err)
+	}
+}
+
+func TestRunBudgets(t *testing.T) {
+	tests := []struct {
+		input    string
+		opts     []Option
+		expected string
+	}{
+		// four instructions: OpConstant, OpPop, OpConstant, OpPop
+		{"1; 2", []Option{WithMaxInstructions(4)}, ""},
+		{"1; 2", []Option{WithMaxInstructions(3)}, "execution budget exceeded: more than 3 instructions"},
+		{"let i = 0; while (i < 10000) { i += 1 }", []Option{WithMaxInstructions(5000)},
+			"execution budget exceeded: more than 5000 instructions"},
+		{"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(10)", []Option{WithMaxFrames(12)}, ""},
+		{"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(11)", []Option{WithMaxFrames(12)},
+			"execution budget exceeded: more than 12 frames"},
+		{"let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(1000)", []Option{WithMaxFrames(2)}, ""},
+		{"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(2000)", nil, "stack overflow"},
+		{"try { while (true) { } } catch { }", []Option{WithMaxInstructions(100)},
+			"execution budget exceeded: more than 100 instructions"},
+		{"let f = fn() { 1 + f() }; try { f() } catch { }", nil, "stack overflow"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		err := New(comp.Bytecode(), tt.opts...).Run(context.Background())
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("%q: unexpected error: %s", tt.input, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%q: wrong error. want=%q, got=%v", tt.input, tt.expected, err)
+			continue
+		}
+		if tt.expected != "stack overflow" && !errors.Is(err, ErrBudgetExceeded) {
+			t.Errorf("%q: error is not ErrBudgetExceeded", tt.input)
+		}
+	}
+}
+
+func TestTryStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"let r = 0; try { throw 5 } catch (e) { r = e } r", 5},
+		{"let r = 0; try { r = 1 } catch (e) { r = 2 } r", 1},
+		{"let r = \"\"; try { len(1) } catch (e) { r = e } r", "argument to `len` not supported, got INTEGER"},
+		{"let n = 0; try { n = 1; throw 0 } catch { n = n * 10 + 2 } finally { n = n * 10 + 3 } n", 123},
+		{"let n = 0; let f = fn() { try { return 1 } finally { n = 5 } }; f() + n", 6},
+		{"fn() { try { return 1 } finally { return 2 } }()", 2},
+		{"fn() { try { throw 1 } finally { return 2 } }()", 2},
+		{"fn() { try { throw 1 } catch (e) { return e + 1 } finally { } }()", 2},
+		{
+			`let f = fn() { try { throw "a" } catch (e) { throw e + "b" } };
+			let r = ""; try { f() } catch (e) { r = e } r`,
+			"ab",
+		},
+		{
+			`let g = fn(n) { if (n == 0) { throw 7 } g(n - 1) + 1 };
+			let r = 0; try { g(5) } catch (e) { r = e } r`,
+			7,
+		},
+		{"let r = 0; try { try { throw 1 } finally { r += 10 } } catch (e) { r += e } r", 11},
+		{
+			`let n = 0;
+			for (x in [1, 2, 3, 4]) {
+				try { if (x == 2) { continue } if (x == 4) { break } n += x } finally { n += 10 }
+			}
+			n`,
+			44,
+		},
+		{
+			`let f = fn() {
+				for (x in [1, 2]) { try { return x } finally { } }
+			};
+			f()`,
+			1,
+		},
+		{"[1, if (true) { try { throw 1 } catch { }; 2 }, 3][1]", 2},
+		{"let add = fn(a, b) { a + b }; add(1, if (true) { try { len(1) } catch { }; 2 })", 3},
+		{"let f = fn() { try { throw 4 } catch (e) { return fn() { e * 2 } } }; f()()", 8},
+		{"let f = fn(n) { try { if (n > 0) { return f(n - 1) } throw n } catch (e) { return e + 1 } }; f(3)", 1},
+	}
+
+	for _, tt := range tests {
+		for _, optimize := range []bool{false, true} {
+			comp := compiler.New(compiler.WithOptimizations(optimize))
+			if err := comp.Compile(parse(tt.input)); err != nil {
+				t.Fatalf("%q: compiler error: %s", tt.input, err)
+			}
+
+			vm := New(comp.Bytecode())
+			if err := vm.Run(context.Background()); err != nil {
+				t.Fatalf("%q (optimize=%t): vm error: %s", tt.input, optimize, err)
+			}
+			testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+			if vm.sp != 0 {
+				t.Errorf("%q (optimize=%t): stack not empty. sp=%d", tt.input, optimize, vm.sp)
+			}
+		}
+	}
+}
+
+func TestUncaughtExceptions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"throw 1", "uncaught exception: 1"},
+		{`throw "boom"`, "uncaught exception: boom"},
+		{"try { throw 1 } catch (e) { throw e + 1 }", "uncaught exception: 2"},
+		{"try { throw 1 } finally { }", "uncaught exception: 1"},
+		{"let f = fn() { throw [1, 2] }; f()", "uncaught exception: [1, 2]"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		err := New(comp.Bytecode()).Run(context.Background())
+		if err == nil {
+			t.Fatalf("%q: expected VM error but resulted in none.", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+		var exc *Exception
+		if !errors.As(err, &exc) {
+			t.Errorf("%q: error is not *Exception. got=%T", tt.input, err)
+		}
+	}
+}
+
+func TestRegistryBuiltins(t *testing.T) {
+	greetings := object.NewRegistry()
+	greetings.Register("greet", 1, func(args ...object.Object) object.Object {
+		return &object.String{Value: "hello " + args[0].Inspect()}
+	}, "")
+
+	numbers := object.NewDefaultRegistry()
+	numbers.Register("double", 1, func(args ...object.Object) object.Object {
+		return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+	}, "")
+
+	tests := []struct {
+		registry *object.Registry
+		input    string
+		expected interface{}
+	}{
+		{greetings, `greet("monkey")`, "hello monkey"},
+		{greetings, `let f = fn(x) { greet(x) }; f(1)`, "hello 1"},
+		{greetings, `try { greet() } catch (e) { e }`, "wrong number of arguments. got=0, want=1"},
+		{numbers, `double(21)`, 42},
+		{numbers, `len([1, 2]) + double(len("abc"))`, 8},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New(compiler.WithBuiltins(tt.registry))
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode(), WithBuiltins(tt.registry))
+		if err := vm.Run(context.Background()); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+	}
+
+	for _, input := range []string{`len("")`, `double(1)`} {
+		comp := compiler.New(compiler.WithBuiltins(greetings))
+		if err := comp.Compile(parse(input)); err == nil {
+			t.Errorf("%q: expected an undefined variable error", input)
+		}
+	}
+}
+
+func TestWrappedGoFunctions(t *testing.T) {
+	r := object.NewDefaultRegistry()
+	if err := r.RegisterFunc("no", func() bool { return false }, ""); err != nil {
+		t.Fatalf("RegisterFunc failed: %s", err)
+	}
+	if err := r.RegisterFunc("nothing", func() *int { return nil }, ""); err != nil {
+		t.Fatalf("RegisterFunc failed: %s", err)
+	}
+
+	tests := []vmTestCase{
+		{"!no()", true},
+		{"no() == false", true},
+		{"no() != false", false},
+		{"if (no()) { 1 } else { 2 }", 2},
+		{"nothing()", Null},
+		{"!nothing()", true},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New(compiler.WithBuiltins(r))
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode(), WithBuiltins(r))
+		if err := vm.Run(context.Background()); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+	}
+}